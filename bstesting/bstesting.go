@@ -1,6 +1,7 @@
 package bstesting
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/Fresh-Tracks/bomb-squad/config"
@@ -33,3 +34,50 @@ func (c *TestConfigurator) Write([]byte) error {
 func (c *TestConfigurator) GetLocation() string {
 	return "testLocal"
 }
+
+// PromConfig returns a copy of the Prometheus config that TestConfigurator serves
+func PromConfig() []byte {
+	return append([]byte{}, promConfigBytes...)
+}
+
+// PromConfigWithSecrets returns a Prometheus config with basic auth passwords
+// and bearer tokens in it, which Prometheus's config types redact when
+// marshalling
+func PromConfigWithSecrets() []byte {
+	return append([]byte{}, promConfigWithSecretsBytes...)
+}
+
+// NewMemConfigurator returns a Configurator that keeps whatever is written to
+// it, starting out with the passed bytes. Like the real ones, it can be read
+// and written from several goroutines.
+func NewMemConfigurator(t *testing.T, b []byte) *MemConfigurator {
+	return &MemConfigurator{
+		T:    t,
+		Data: b,
+	}
+}
+
+type MemConfigurator struct {
+	T      *testing.T
+	Data   []byte
+	Writes int
+	lock   sync.Mutex
+}
+
+func (c *MemConfigurator) Read() ([]byte, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.Data, nil
+}
+
+func (c *MemConfigurator) Write(b []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Data = b
+	c.Writes++
+	return nil
+}
+
+func (c *MemConfigurator) GetLocation() string {
+	return "memLocal"
+}
@@ -62,5 +62,31 @@ scrape_configs:
       regex: (.+):(?:\d+);(\d+)
       replacement: ${1}:${2}
       target_label: __address__
+`)
+	promConfigWithSecretsBytes = []byte(`
+global:
+  scrape_interval: 15s
+remote_write:
+- url: https://remote.example.com/api/v1/write
+  basic_auth:
+    username: bomb-squad
+    password: hunter2
+scrape_configs:
+- job_name: prometheus
+  static_configs:
+  - targets:
+    - localhost:9090
+- job_name: federate
+  bearer_token: s3cr3t-t0k3n
+  static_configs:
+  - targets:
+    - federate.example.com:9090
+- job_name: node
+  basic_auth:
+    username: node
+    password: n0d3-p4ss
+  static_configs:
+  - targets:
+    - localhost:9100
 `)
 )
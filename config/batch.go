@@ -0,0 +1,77 @@
+package config
+
+import "bytes"
+
+// BatchConfigurator holds back writes to the wrapped Configurator until
+// Flush, so that several changes go out as one write. Reads see the held back
+// write, as if it had gone through.
+type BatchConfigurator struct {
+	Configurator
+	pending []byte
+	dirty   bool
+	// base is what was first read from the wrapped Configurator
+	base     []byte
+	baseRead bool
+}
+
+// NewBatchConfigurator returns a BatchConfigurator wrapping c
+func NewBatchConfigurator(c Configurator) *BatchConfigurator {
+	return &BatchConfigurator{Configurator: c}
+}
+
+// Read implements Configurator
+func (c *BatchConfigurator) Read() ([]byte, error) {
+	if c.dirty {
+		return append([]byte{}, c.pending...), nil
+	}
+	b, err := c.Configurator.Read()
+	if err == nil && !c.baseRead {
+		c.base, c.baseRead = append([]byte{}, b...), true
+	}
+	return b, err
+}
+
+// Write implements Configurator
+func (c *BatchConfigurator) Write(b []byte) error {
+	c.pending = append([]byte{}, b...)
+	c.dirty = true
+	return nil
+}
+
+// Pending reports whether there's a write waiting for Flush
+func (c *BatchConfigurator) Pending() bool {
+	return c.dirty
+}
+
+// Flush writes whatever was last written through to the wrapped Configurator
+func (c *BatchConfigurator) Flush() error {
+	if !c.dirty {
+		return nil
+	}
+	err := c.Configurator.Write(c.pending)
+	if err != nil {
+		return err
+	}
+	c.base, c.baseRead = c.pending, true
+	c.pending, c.dirty = nil, false
+	return nil
+}
+
+// Changed reports whether the wrapped Configurator no longer holds what was
+// first read from it, ex. because someone else wrote it since, in which case
+// the held back write would overwrite their change
+func (c *BatchConfigurator) Changed() (bool, error) {
+	if !c.baseRead {
+		return false, nil
+	}
+	b, err := c.Configurator.Read()
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(b, c.base), nil
+}
+
+// Discard forgets the held back write
+func (c *BatchConfigurator) Discard() {
+	c.pending, c.dirty = nil, false
+}
@@ -0,0 +1,52 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchConfiguratorHoldsBackWrites(t *testing.T) {
+	mem := bstesting.NewMemConfigurator(t, []byte("first"))
+	c := config.NewBatchConfigurator(mem)
+
+	require.NoError(t, c.Write([]byte("second")))
+	require.NoError(t, c.Write([]byte("third")))
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, "third", string(b))
+	require.Equal(t, 0, mem.Writes)
+
+	require.NoError(t, c.Flush())
+	require.Equal(t, "third", string(mem.Data))
+	require.Equal(t, 1, mem.Writes)
+
+	// Nothing left to write
+	require.NoError(t, c.Flush())
+	require.Equal(t, 1, mem.Writes)
+
+	require.NoError(t, c.Write([]byte("fourth")))
+	c.Discard()
+	b, err = c.Read()
+	require.NoError(t, err)
+	require.Equal(t, "third", string(b))
+}
+
+func TestBatchConfiguratorNoticesOtherWrites(t *testing.T) {
+	mem := bstesting.NewMemConfigurator(t, []byte("first"))
+	c := config.NewBatchConfigurator(mem)
+
+	_, err := c.Read()
+	require.NoError(t, err)
+	require.NoError(t, c.Write([]byte("ours")))
+	changed, err := c.Changed()
+	require.NoError(t, err)
+	require.False(t, changed)
+
+	require.NoError(t, mem.Write([]byte("theirs")))
+	changed, err = c.Changed()
+	require.NoError(t, err)
+	require.True(t, changed)
+}
@@ -1,11 +1,14 @@
 package config
 
 import (
-	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/Fresh-Tracks/bomb-squad/util"
 	"github.com/prometheus/common/model"
@@ -19,10 +22,63 @@ type Configurator interface {
 	GetLocation() string
 }
 
-type BombSquadLabelConfig map[string]string
-
 type BombSquadConfig struct {
-	SuppressedMetrics map[string]BombSquadLabelConfig
+	// Version is the schema version the config was written with. Configs
+	// from before silences were recorded individually have none, and are
+	// migrated when read.
+	Version int `yaml:"Version"`
+	// Silences maps the key of every silence, as shown by `bs list`, to its
+	// record
+	Silences map[string]Silence `yaml:"Silences,omitempty"`
+
+	// Strategy is the default suppression strategy for exploding label
+	// values, and MetricStrategies overrides it for individual metrics. One of
+	// replace (the default), drop, labeldrop, hashmod or topk.
+	Strategy         string            `yaml:"Strategy,omitempty"`
+	MetricStrategies map[string]string `yaml:"MetricStrategies,omitempty"`
+	// HashModBuckets is how many values the hashmod strategy keeps
+	HashModBuckets int `yaml:"HashModBuckets,omitempty"`
+	// TopKValues is how many values the topk strategy keeps, and OtherValue
+	// is what it rewrites the rest to
+	TopKValues int    `yaml:"TopKValues,omitempty"`
+	OtherValue string `yaml:"OtherValue,omitempty"`
+	// Incidents maps exploding metrics to the steps taken to stop them, when
+	// silences are being verified
+	Incidents map[string]Incident `yaml:"Incidents,omitempty"`
+}
+
+// SilenceScope limits a silence to the scrape jobs actually emitting the
+// exploding series
+type SilenceScope struct {
+	Jobs []string `yaml:"jobs,omitempty"`
+	// Labels maps labels such as namespace or pod to the values a silence is
+	// limited to within those jobs
+	Labels map[string][]string `yaml:"labels,omitempty"`
+}
+
+func (s SilenceScope) String() string {
+	parts := []string{"jobs: all"}
+	if len(s.Jobs) > 0 {
+		parts[0] = fmt.Sprintf("jobs: %s", strings.Join(s.Jobs, ", "))
+	}
+	for _, name := range scopeLabelNames(s.Labels) {
+		parts = append(parts, fmt.Sprintf("%s: %s", name, strings.Join(s.Labels[name], ", ")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// HasJob reports whether the scope covers the named scrape job. An empty
+// scope covers every job.
+func (s SilenceScope) HasJob(job string) bool {
+	if len(s.Jobs) == 0 {
+		return true
+	}
+	for _, j := range s.Jobs {
+		if j == job {
+			return true
+		}
+	}
+	return false
 }
 
 func ReadBombSquadConfig(c Configurator) (BombSquadConfig, error) {
@@ -36,8 +92,20 @@ func ReadBombSquadConfig(c Configurator) (BombSquadConfig, error) {
 	if err != nil {
 		return BombSquadConfig{}, fmt.Errorf("Couldn't unmarshal into config.BombSquadConfig: %s", err)
 	}
-	if bscfg.SuppressedMetrics == nil {
-		bscfg.SuppressedMetrics = map[string]BombSquadLabelConfig{}
+	if bscfg.Version > SchemaVersion {
+		return BombSquadConfig{}, fmt.Errorf("Bomb Squad config has schema version %d, this build only knows up to %d", bscfg.Version, SchemaVersion)
+	}
+	if bscfg.Silences == nil {
+		bscfg.Silences = map[string]Silence{}
+	}
+	if bscfg.Version < SchemaVersion {
+		err = migrateBombSquadConfig(b, &bscfg)
+		if err != nil {
+			return BombSquadConfig{}, err
+		}
+	}
+	if bscfg.Incidents == nil {
+		bscfg.Incidents = map[string]Incident{}
 	}
 
 	return bscfg, nil
@@ -58,6 +126,7 @@ func ReadPromConfig(c Configurator) (promcfg.Config, error) {
 }
 
 func WriteBombSquadConfig(bscfg BombSquadConfig, c Configurator) error {
+	bscfg.Version = SchemaVersion
 	b, err := yaml.Marshal(bscfg)
 	if err != nil {
 		log.Printf("Failed to write Bomb Squad config: %s\n", err)
@@ -66,26 +135,118 @@ func WriteBombSquadConfig(bscfg BombSquadConfig, c Configurator) error {
 	return c.Write(b)
 }
 
+// WritePromConfig writes the Prometheus config. When all that changed are
+// metric relabel configs and sample_limits, which is all silencing and
+// unsilencing ever change, only the lines involved are edited, so comments,
+// key order and formatting in the rest of the config survive.
 func WritePromConfig(pcfg promcfg.Config, c Configurator) error {
-	b, err := yaml.Marshal(pcfg)
+	b, err := renderPromConfig(pcfg, c)
 	if err != nil {
 		log.Printf("Failed to write Prometheus config: %s\n", err)
 		return err
 	}
+
+	err = ValidatePromConfig(b)
+	if err != nil {
+		log.Printf("Refusing to write Prometheus config: %s\n", err)
+		return err
+	}
 	return c.Write(b)
 }
 
-func ListSuppressedMetrics(c Configurator) {
+// renderPromConfig renders the Prometheus config to be written, by editing
+// the current one where possible. Rewriting it whole keeps the secrets of the
+// current one, rather than Prometheus's placeholders for them.
+func renderPromConfig(pcfg promcfg.Config, c Configurator) ([]byte, error) {
+	current, err := c.Read()
+	if err == nil && len(current) > 0 {
+		b, err := EditPromConfig(current, pcfg)
+		if err == nil {
+			return b, nil
+		}
+		if err != errBeyondSuppressions {
+			log.Printf("Couldn't edit Prometheus config in place, rewriting it whole: %s\n", err)
+		}
+	}
+
+	b, err := yaml.Marshal(pcfg)
+	if err != nil {
+		return nil, err
+	}
+	return restoreSecrets(b, current)
+}
+
+// promConfigSuppressions is the part of a Prometheus config that Bomb Squad
+// writes to. Everything else is left as the operator wrote it.
+type promConfigSuppressions struct {
+	ScrapeConfigs []struct {
+		JobName              string                   `yaml:"job_name"`
+		MetricRelabelConfigs []*promcfg.RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+	} `yaml:"scrape_configs"`
+}
+
+// ValidatePromConfig checks the metric relabel configs of a rendered
+// Prometheus config, compiling every regex the way Prometheus would. It also
+// checks that no metric relabel config takes out the metric name of every
+// series it sees. The rest of the document isn't loaded strictly, so fields
+// this build of Prometheus doesn't know about are kept and don't fail it.
+func ValidatePromConfig(b []byte) error {
+	pcfg := promConfigSuppressions{}
+	err := yaml.Unmarshal(b, &pcfg)
+	if err != nil {
+		return fmt.Errorf("Prometheus config is invalid: %s", err)
+	}
+
+	for _, scrapeConfig := range pcfg.ScrapeConfigs {
+		for _, rc := range scrapeConfig.MetricRelabelConfigs {
+			// Unmarshalling already compiled every regex
+			matchesName := rc.Regex.MatchString(string(model.MetricNameLabel))
+			if rc.Action == promcfg.RelabelLabelDrop && matchesName || rc.Action == promcfg.RelabelLabelKeep && !matchesName {
+				return fmt.Errorf("Metric relabel config in ScrapeConfig %s would drop the %s label", scrapeConfig.JobName, model.MetricNameLabel)
+			}
+		}
+	}
+	return nil
+}
+
+// ListSuppressedMetrics prints every silence recorded in the Bomb Squad config
+func ListSuppressedMetrics(c Configurator) error {
 	b, err := ReadBombSquadConfig(c)
 	if err != nil {
-		log.Fatalf("Couldn't list suppressed metrics: %s\n", err)
+		return fmt.Errorf("Couldn't list suppressed metrics: %s", err)
+	}
+
+	for _, s := range b.sortedSilences(SilenceLabelValues) {
+		fmt.Println(s)
+	}
+
+	if silences := b.sortedSilences(SilenceLabelNames); len(silences) > 0 {
+		fmt.Println("Dropped Label Names (metricName.labelNamePattern):")
+		for _, s := range silences {
+			fmt.Println(s)
+		}
+	}
+
+	if silences := b.sortedSilences(SilenceMetricNames); len(silences) > 0 {
+		fmt.Println("Dropped Metric Names (metricNamePattern):")
+		for _, s := range silences {
+			fmt.Println(s)
+		}
 	}
+	return nil
+}
 
-	for metric, labels := range b.SuppressedMetrics {
-		for label := range labels {
-			fmt.Printf("%s.%s\n", metric, label)
+// scopesUsingRelabelConfig returns the scopes of every silence that still
+// relies on the relabel config. A labeldrop rule applies to every series in a
+// scrape config, so several metrics exploding the same way will share one.
+func (b BombSquadConfig) scopesUsingRelabelConfig(rc promcfg.RelabelConfig) []SilenceScope {
+	scopes := []SilenceScope{}
+	for _, s := range b.Silences {
+		if s.UsesRule(rc) {
+			scopes = append(scopes, s.Scope)
 		}
 	}
+	return scopes
 }
 
 func RemoveSilence(label string, pc, bc Configurator) error {
@@ -94,28 +255,36 @@ func RemoveSilence(label string, pc, bc Configurator) error {
 		return err
 	}
 
-	ml := strings.Split(label, ".")
-	metricName, labelName := ml[0], ml[1]
-
 	bsCfg, err := ReadBombSquadConfig(bc)
 	if err != nil {
 		return err
 	}
 
-	bsRelabelConfigEncoded := bsCfg.SuppressedMetrics[metricName][labelName]
-
-	for _, scrapeConfig := range promConfig.ScrapeConfigs {
-		i := FindRelabelConfigInScrapeConfig(bsRelabelConfigEncoded, *scrapeConfig)
-		if i >= 0 {
-			scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
-			fmt.Printf("Deleted silence rule from ScrapeConfig %s\n", scrapeConfig.JobName)
+	silence, ok := bsCfg.FindSilence(label)
+	if !ok {
+		return fmt.Errorf("No silence found for '%s'", label)
+	}
+	delete(bsCfg.Silences, silence.Key())
+
+	for _, rule := range silence.Rules {
+		stillNeeded := bsCfg.scopesUsingRelabelConfig(rule)
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			if !silence.Scope.HasJob(scrapeConfig.JobName) || scopesHaveJob(stillNeeded, scrapeConfig.JobName) {
+				continue
+			}
+			i := FindRelabelConfigInScrapeConfig(rule, *scrapeConfig)
+			if i >= 0 {
+				scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+				fmt.Printf("Deleted silence rule from ScrapeConfig %s\n", scrapeConfig.JobName)
+			}
 		}
 	}
 
-	if len(bsCfg.SuppressedMetrics[metricName]) == 1 {
-		delete(bsCfg.SuppressedMetrics, metricName)
-	} else {
-		delete(bsCfg.SuppressedMetrics[metricName], labelName)
+	// Prometheus first, so a failed write never leaves a silence in place that
+	// the Bomb Squad config has forgotten
+	err = WritePromConfig(promConfig, pc)
+	if err != nil {
+		return err
 	}
 
 	err = WriteBombSquadConfig(bsCfg, bc)
@@ -123,36 +292,166 @@ func RemoveSilence(label string, pc, bc Configurator) error {
 		return err
 	}
 
-	err = WritePromConfig(promConfig, pc)
+	resetType := ""
+	if silence.Kind != SilenceLabelValues {
+		resetType = silence.Kind
+	}
+	resetMetric(silence.Metric, silence.Label, resetType)
+
+	return nil
+}
+
+func StoreMetricRelabelConfigBombSquad(s HighCardSeries, suppressor Suppressor, mrcs []promcfg.RelabelConfig, c Configurator) error {
+	b, err := ReadBombSquadConfig(c)
 	if err != nil {
 		return err
 	}
 
-	resetMetric(metricName, labelName)
+	silence := Silence{
+		Kind:         SilenceLabelValues,
+		Metric:       s.MetricName,
+		Label:        string(s.HighCardLabelName),
+		Created:      time.Now().UTC(),
+		Origin:       s.Origin,
+		Strategy:     suppressor.Strategy(),
+		Cardinality:  len(s.ValueCounts),
+		SampleValues: sampleValues(s.ValueCounts),
+		Scope:        SilenceScope{Jobs: s.Jobs, Labels: s.Scope},
+		Rules:        mrcs,
+	}
+	if t, ok := suppressor.(TopKSuppressor); ok {
+		silence.KeptValues = t.Keep(s)
+	}
+	b.putSilence(silence)
 
-	return nil
+	return WriteBombSquadConfig(b, c)
 }
 
-func StoreMetricRelabelConfigBombSquad(s HighCardSeries, mrc promcfg.RelabelConfig, c Configurator) error {
+func StoreLabelDropRelabelConfigBombSquad(e ExplodingLabelNames, mrc promcfg.RelabelConfig, c Configurator) error {
 	b, err := ReadBombSquadConfig(c)
 	if err != nil {
 		return err
 	}
 
-	if lc, ok := b.SuppressedMetrics[s.MetricName]; ok {
-		lc[string(s.HighCardLabelName)] = encode(mrc)
+	b.putSilence(Silence{
+		Kind:         SilenceLabelNames,
+		Metric:       e.MetricName,
+		Label:        e.Pattern,
+		Created:      time.Now().UTC(),
+		Strategy:     StrategyLabelDrop,
+		Cardinality:  e.Count,
+		SampleValues: firstValues(e.Names),
+		Scope:        SilenceScope{Jobs: e.Jobs},
+		Rules:        []promcfg.RelabelConfig{mrc},
+	})
+
+	return WriteBombSquadConfig(b, c)
+}
+
+func StoreMetricNameDropRelabelConfigBombSquad(e ExplodingMetricNames, mrc promcfg.RelabelConfig, c Configurator) error {
+	b, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	b.putSilence(Silence{
+		Kind:         SilenceMetricNames,
+		Metric:       e.Pattern,
+		Created:      time.Now().UTC(),
+		Strategy:     StrategyDrop,
+		Cardinality:  e.Count,
+		SampleValues: firstValues(e.Names),
+		Scope:        SilenceScope{Jobs: e.Jobs},
+		Rules:        []promcfg.RelabelConfig{mrc},
+	})
+
+	return WriteBombSquadConfig(b, c)
+}
+
+// MergeSilenceScope widens the scope of the series to include that of any
+// existing silence on the same metric and label, so a second offender doesn't
+// replace the first. It returns the relabel configs of the existing silence,
+// which the caller should remove if they no longer match.
+func MergeSilenceScope(s *HighCardSeries, c Configurator) ([]promcfg.RelabelConfig, error) {
+	b, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	silence, ok := b.Silences[fmt.Sprintf("%s.%s", s.MetricName, s.HighCardLabelName)]
+	if !ok || silence.Kind != SilenceLabelValues {
+		return nil, nil
+	}
+
+	existing := silence.Scope
+	if len(existing.Jobs) == 0 {
+		s.Jobs = nil
 	} else {
-		b.SuppressedMetrics[s.MetricName] = lc
-		lc = BombSquadLabelConfig{}
-		lc[string(s.HighCardLabelName)] = encode(mrc)
+		s.Jobs = unionStrings(existing.Jobs, s.Jobs)
+	}
+
+	if len(existing.Labels) == 0 || len(s.Scope) == 0 {
+		// The label is already silenced for every target
+		s.Scope = nil
+		return silence.Rules, nil
 	}
 
-	err = WriteBombSquadConfig(b, c)
+	merged := map[string][]string{}
+	for name, values := range s.Scope {
+		old, ok := existing.Labels[name]
+		if !ok {
+			continue
+		}
+		merged[name] = unionStrings(old, values)
+	}
+	if len(merged) == 0 {
+		merged = nil
+	}
+	s.Scope = merged
+
+	return silence.Rules, nil
+}
+
+// RemoveMetricRelabelConfigFromPromConfig deletes the relabel configs from
+// every scrape config that has them
+func RemoveMetricRelabelConfigFromPromConfig(rules []promcfg.RelabelConfig, c Configurator) (promcfg.Config, error) {
+	promConfig, err := ReadPromConfig(c)
 	if err != nil {
-		log.Fatalf("Failed to write BombSquadConfig: %s\n", err)
+		return promcfg.Config{}, err
 	}
 
-	return nil
+	for _, rule := range rules {
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			i := FindRelabelConfigInScrapeConfig(rule, *scrapeConfig)
+			if i >= 0 {
+				scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+				fmt.Printf("Deleted replaced silence rule from ScrapeConfig %s\n", scrapeConfig.JobName)
+			}
+		}
+	}
+	return promConfig, nil
+}
+
+func unionStrings(a, b []string) []string {
+	seen := map[string]bool{}
+	res := []string{}
+	for _, v := range append(append([]string{}, a...), b...) {
+		if !seen[v] {
+			seen[v] = true
+			res = append(res, v)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func scopesHaveJob(scopes []SilenceScope, job string) bool {
+	for _, s := range scopes {
+		if s.HasJob(job) {
+			return true
+		}
+	}
+	return false
 }
 
 func DeleteRelabelConfigFromArray(arr []*promcfg.RelabelConfig, index int) []*promcfg.RelabelConfig {
@@ -165,9 +464,9 @@ func DeleteRelabelConfigFromArray(arr []*promcfg.RelabelConfig, index int) []*pr
 	return res
 }
 
-func FindRelabelConfigInScrapeConfig(encodedRule string, scrapeConfig promcfg.ScrapeConfig) int {
+func FindRelabelConfigInScrapeConfig(rule promcfg.RelabelConfig, scrapeConfig promcfg.ScrapeConfig) int {
 	for i, relabelConfig := range scrapeConfig.MetricRelabelConfigs {
-		if encode(*relabelConfig) == encodedRule {
+		if SameRelabelConfig(*relabelConfig, rule) {
 			return i
 		}
 	}
@@ -175,31 +474,44 @@ func FindRelabelConfigInScrapeConfig(encodedRule string, scrapeConfig promcfg.Sc
 	return -1
 }
 
-func InsertMetricRelabelConfigToPromConfig(rc promcfg.RelabelConfig, c Configurator) (promcfg.Config, error) {
+// InsertMetricRelabelConfigToPromConfig adds the relabel configs, in order, to
+// the scrape configs for the passed jobs, or to every scrape config if jobs is
+// empty. It
+// returns the jobs the silence is now scoped to, which is nil if none of the
+// passed jobs matched a scrape config (ex. the job label was rewritten by
+// relabeling) and we had to fall back to all of them.
+func InsertMetricRelabelConfigToPromConfig(rcs []promcfg.RelabelConfig, jobs []string, c Configurator) (promcfg.Config, []string, error) {
 	promConfig, err := ReadPromConfig(c)
 	if err != nil {
-		return promcfg.Config{}, err
+		return promcfg.Config{}, nil, err
 	}
 
-	rcEncoded := encode(rc)
+	scope := SilenceScope{}
 	for _, scrapeConfig := range promConfig.ScrapeConfigs {
-		if FindRelabelConfigInScrapeConfig(rcEncoded, *scrapeConfig) == -1 {
-			fmt.Printf("Did not find necessary silence rule in ScrapeConfig %s, adding now\n", scrapeConfig.JobName)
-			scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rc)
+		if (SilenceScope{Jobs: jobs}).HasJob(scrapeConfig.JobName) {
+			scope.Jobs = append(scope.Jobs, scrapeConfig.JobName)
 		}
 	}
-	return promConfig, nil
-}
-
-func encode(rc promcfg.RelabelConfig) string {
-	b, err := yaml.Marshal(rc)
-	if err != nil {
-		// Bail here, because there's no point continuing anything else if we can't encode to a string
-		log.Fatalf("Failed to encode relabel config: %s\n", err)
+	if len(scope.Jobs) == 0 && len(jobs) > 0 {
+		fmt.Printf("None of the jobs %v match a ScrapeConfig, adding silence rule to all of them\n", jobs)
+	}
+	if len(jobs) == 0 || len(scope.Jobs) == 0 {
+		scope.Jobs = nil
 	}
 
-	s := fmt.Sprintf("%s", string(b))
-	return base64.StdEncoding.EncodeToString([]byte(s))
+	for i := range rcs {
+		rc := rcs[i]
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			if !scope.HasJob(scrapeConfig.JobName) {
+				continue
+			}
+			if FindRelabelConfigInScrapeConfig(rc, *scrapeConfig) == -1 {
+				fmt.Printf("Did not find necessary silence rule in ScrapeConfig %s, adding now\n", scrapeConfig.JobName)
+				scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rc)
+			}
+		}
+	}
+	return promConfig, scope.Jobs, nil
 }
 
 func ConfigGetRuleFiles() []string {
@@ -211,22 +523,60 @@ func ConfigGetRuleFiles() []string {
 type HighCardSeries struct {
 	MetricName        string
 	HighCardLabelName model.LabelName
+	// Jobs are the values of the job label on the exploding series
+	Jobs []string
+	// Scope maps labels such as namespace or pod to the values responsible for
+	// the explosion. When set, only series carrying those values are silenced.
+	Scope map[string][]string
+	// ValueCounts maps each value of the exploding label to how many series
+	// carry it
+	ValueCounts map[string]int
+	// Origin is where a silence of the series comes from, OriginAuto if empty
+	Origin string
+}
+
+// scopeLabelNames returns the labels of a silence scope in a stable order
+func scopeLabelNames(scope map[string][]string) []string {
+	names := []string{}
+	for name := range scope {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scopedMatch returns the source labels and the start of a regex that match
+// the series' metric name, and any scope labels it is limited to. Callers
+// append the exploding label and what its value should match.
+func scopedMatch(s HighCardSeries) (model.LabelNames, string) {
+	sourceLabels := model.LabelNames{"__name__"}
+	regexpPrefix := fmt.Sprintf("^%s;", s.MetricName)
+	for _, name := range scopeLabelNames(s.Scope) {
+		values := []string{}
+		for _, v := range s.Scope[name] {
+			values = append(values, regexp.QuoteMeta(v))
+		}
+		sourceLabels = append(sourceLabels, model.LabelName(name))
+		regexpPrefix += fmt.Sprintf("(?:%s);", strings.Join(values, "|"))
+	}
+	return sourceLabels, regexpPrefix
 }
 
-// TODO: Only generate the relabel config for the appropriate job that is spitting out
-// the high-cardinality metric
-// TODO: Within a job, some series may never be exploding on this label. Consider including
-// all relevant labels in source_labels...?
+// silenceValue is what the replace strategy rewrites exploding label values to
+const silenceValue = "bs_silence"
+
 func GenerateMetricRelabelConfig(s HighCardSeries) (promcfg.RelabelConfig, error) {
-	valueReplace := "bs_silence"
-	regexpOriginal := fmt.Sprintf("^%s;.*$", s.MetricName)
+	valueReplace := silenceValue
+	sourceLabels, regexpPrefix := scopedMatch(s)
+	regexpOriginal := regexpPrefix + ".*$"
+
 	promRegex, err := promcfg.NewRegexp(regexpOriginal)
 	if err != nil {
 		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", regexpOriginal, err)
 	}
 
 	newMetricRelabelConfig := promcfg.RelabelConfig{
-		SourceLabels: model.LabelNames{"__name__", s.HighCardLabelName},
+		SourceLabels: append(sourceLabels, s.HighCardLabelName),
 		Regex:        promRegex,
 		TargetLabel:  string(s.HighCardLabelName),
 		Replacement:  valueReplace,
@@ -235,12 +585,71 @@ func GenerateMetricRelabelConfig(s HighCardSeries) (promcfg.RelabelConfig, error
 	return newMetricRelabelConfig, nil
 }
 
-func resetMetric(metricName, labelName string) {
+// ExplodingLabelNames represents a metric whose set of label names is growing,
+// along with a pattern that matches the newly-sprouted label names
+type ExplodingLabelNames struct {
+	MetricName string
+	Pattern    string
+	Count      int
+	Jobs       []string
+	// Names are the new label names, sorted
+	Names []string
+}
+
+// GenerateLabelDropRelabelConfig drops every label matching the exploding
+// pattern. Note that labeldrop has no notion of which metric a label belongs
+// to, so this applies to all series in the scrape config.
+func GenerateLabelDropRelabelConfig(e ExplodingLabelNames) (promcfg.RelabelConfig, error) {
+	promRegex, err := promcfg.NewRegexp(e.Pattern)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", e.Pattern, err)
+	}
+
+	newMetricRelabelConfig := promcfg.RelabelConfig{
+		Regex:  promRegex,
+		Action: promcfg.RelabelLabelDrop,
+	}
+	return newMetricRelabelConfig, nil
+}
+
+// ExplodingMetricNames represents a surge of new metric names, all matching
+// Pattern, coming from Jobs
+type ExplodingMetricNames struct {
+	Pattern string
+	Count   int
+	Jobs    []string
+	// Names are the new metric names, sorted
+	Names []string
+}
+
+// GenerateMetricNameDropRelabelConfig drops every series whose metric name
+// matches the exploding pattern
+func GenerateMetricNameDropRelabelConfig(e ExplodingMetricNames) (promcfg.RelabelConfig, error) {
+	promRegex, err := promcfg.NewRegexp(e.Pattern)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", e.Pattern, err)
+	}
+
+	newMetricRelabelConfig := promcfg.RelabelConfig{
+		SourceLabels: model.LabelNames{"__name__"},
+		Regex:        promRegex,
+		Action:       promcfg.RelabelDrop,
+	}
+	return newMetricRelabelConfig, nil
+}
+
+func resetMetric(metricName, labelName, resetType string) {
 	client, _ := util.HttpClient()
 	// TODO This is a hack currently, to allow the CLI invocation of `unsilence` to actually get to the metric
 	// that needs reset. Should not assume that CLI will be invoked from the running instance, and make this
 	// configurable
-	endpt := fmt.Sprintf("http://localhost:8080/metrics/reset?metric=%s&label=%s", metricName, labelName)
+	q := url.Values{}
+	q.Set("metric", metricName)
+	q.Set("label", labelName)
+	if resetType != "" {
+		q.Set("type", resetType)
+	}
+	endpt := fmt.Sprintf("http://localhost:8080/metrics/reset?%s", q.Encode())
 	req, _ := http.NewRequest("GET", endpt, nil)
 
 	_, err := client.Do(req)
@@ -5,6 +5,9 @@ import (
 
 	"github.com/Fresh-Tracks/bomb-squad/bstesting"
 	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfgpkg "github.com/prometheus/prometheus/config"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,6 +25,33 @@ func TestCanWritePromConfig(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestRefusesInvalidPromConfig(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	promcfg, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+
+	promcfg.ScrapeConfigs[0].MetricRelabelConfigs = []*promcfgpkg.RelabelConfig{{
+		SourceLabels: []model.LabelName{"foo"},
+		TargetLabel:  "bar",
+		Action:       promcfgpkg.RelabelHashMod,
+	}}
+	require.Error(t, config.WritePromConfig(promcfg, c))
+
+	promcfg.ScrapeConfigs[0].MetricRelabelConfigs = []*promcfgpkg.RelabelConfig{{
+		Regex:  promcfgpkg.MustNewRegexp("__.*"),
+		Action: promcfgpkg.RelabelLabelDrop,
+	}}
+	require.Error(t, config.WritePromConfig(promcfg, c))
+	require.Equal(t, 0, c.Writes)
+
+	promcfg.ScrapeConfigs[0].MetricRelabelConfigs = []*promcfgpkg.RelabelConfig{{
+		Regex:  promcfgpkg.MustNewRegexp("__tmp.*"),
+		Action: promcfgpkg.RelabelLabelDrop,
+	}}
+	require.NoError(t, config.WritePromConfig(promcfg, c))
+	require.Equal(t, 1, c.Writes)
+}
+
 func TestCanReadBombSquadConfig(t *testing.T) {
 	c := bstesting.NewConfigurator(t)
 	bscfg, err := config.ReadBombSquadConfig(c)
@@ -41,9 +71,92 @@ func TestCanInsertMetricRelabelConfigToPromConfig(t *testing.T) {
 	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
 	mrc, err := config.GenerateMetricRelabelConfig(hcs)
 	require.NoError(t, err)
-	promcfg, err := config.InsertMetricRelabelConfigToPromConfig(mrc, c)
+	promcfg, jobs, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, nil, c)
 	require.NoError(t, err)
+	require.Nil(t, jobs)
 	insertedMRC := promcfg.ScrapeConfigs[0].MetricRelabelConfigs[0]
 	require.Equal(t, "bar", insertedMRC.TargetLabel)
 	require.Equal(t, "^(?:^foo;.*$)$", insertedMRC.Regex.String())
 }
+
+func TestCanScopeSilenceToJobs(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"bomb-squad", "not-a-job"}}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	require.NoError(t, prom.ReUnmarshal(&mrc))
+	promcfg, jobs, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, hcs.Jobs, pc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bomb-squad"}, jobs)
+	for _, sc := range promcfg.ScrapeConfigs {
+		if sc.JobName == "bomb-squad" {
+			require.Len(t, sc.MetricRelabelConfigs, 1)
+		} else {
+			require.Empty(t, sc.MetricRelabelConfigs)
+		}
+	}
+
+	require.NoError(t, config.WritePromConfig(promcfg, pc))
+	hcs.Jobs = jobs
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{mrc}, bc))
+
+	bscfg, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Equal(t, []string{"bomb-squad"}, bscfg.Silences["foo.bar"].Scope.Jobs)
+
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	promcfg, err = config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range promcfg.ScrapeConfigs {
+		require.Empty(t, sc.MetricRelabelConfigs)
+	}
+	bscfg, err = config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Empty(t, bscfg.Silences)
+}
+
+func TestStoringSilenceReturnsWriteErrors(t *testing.T) {
+	bc := &flakyConfigurator{MemConfigurator: bstesting.NewMemConfigurator(t, []byte{}), broken: true}
+	mrc := silenceRule(t, "foo", "bar")
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	require.Error(t, config.StoreMetricRelabelConfigBombSquad(hcs, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{mrc}, bc))
+	require.Error(t, config.StoreLabelDropRelabelConfigBombSquad(config.ExplodingLabelNames{MetricName: "foo", Pattern: "bar_.*"}, mrc, bc))
+	require.Error(t, config.StoreMetricNameDropRelabelConfigBombSquad(config.ExplodingMetricNames{Pattern: "foo_.*"}, mrc, bc))
+}
+
+func TestCanGenerateLabelDropRelabelConfig(t *testing.T) {
+	e := config.ExplodingLabelNames{MetricName: "foo", Pattern: "tag_.*", Count: 50}
+	mrc, err := config.GenerateLabelDropRelabelConfig(e)
+	require.NoError(t, err)
+	require.Equal(t, "labeldrop", string(mrc.Action))
+	require.Empty(t, mrc.SourceLabels)
+	require.True(t, mrc.Regex.MatchString("tag_customer42"))
+	require.False(t, mrc.Regex.MatchString("instance"))
+}
+
+func TestCanGenerateMetricNameDropRelabelConfig(t *testing.T) {
+	e := config.ExplodingMetricNames{Pattern: "http_req_.*_seconds", Count: 500}
+	mrc, err := config.GenerateMetricNameDropRelabelConfig(e)
+	require.NoError(t, err)
+	require.Equal(t, "drop", string(mrc.Action))
+	require.Equal(t, "__name__", string(mrc.SourceLabels[0]))
+	require.True(t, mrc.Regex.MatchString("http_req_1f2e_seconds"))
+	require.False(t, mrc.Regex.MatchString("http_requests_total"))
+}
+
+func TestCanGenerateScopedMetricRelabelConfig(t *testing.T) {
+	hcs := config.HighCardSeries{
+		MetricName:        "foo",
+		HighCardLabelName: "bar",
+		Scope:             map[string][]string{"pod": {"web-1", "web.2"}, "namespace": {"prod"}},
+	}
+	mrc, err := config.GenerateMetricRelabelConfig(hcs)
+	require.NoError(t, err)
+	require.Equal(t, "__name__, namespace, pod, bar", mrc.SourceLabels.String())
+	require.True(t, mrc.Regex.MatchString("foo;prod;web.2;abc123"))
+	require.False(t, mrc.Regex.MatchString("foo;prod;web-3;abc123"))
+	require.False(t, mrc.Regex.MatchString("foo;prod;webx2;abc123"))
+}
@@ -0,0 +1,372 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// errBeyondSuppressions is returned by EditPromConfig for changes it won't make
+var errBeyondSuppressions = fmt.Errorf("config changed beyond metric relabel configs and sample limits")
+
+// yamlKeyLine matches a block mapping key, optionally as the first key of a
+// sequence item, capturing the indentation, item marker, key and the rest
+var yamlKeyLine = regexp.MustCompile(`^(\s*)(-\s+)?([A-Za-z_][A-Za-z0-9_]*):(.*)$`)
+
+// yamlLine is one line of a YAML document, along with what we need to know
+// to find our way around it without a full YAML parser
+type yamlLine struct {
+	text    string
+	indent  int
+	content bool
+}
+
+func splitYAMLLines(doc []byte) []yamlLine {
+	lines := []yamlLine{}
+	for _, text := range strings.Split(string(doc), "\n") {
+		trimmed := strings.TrimLeft(text, " ")
+		lines = append(lines, yamlLine{
+			text:    text,
+			indent:  len(text) - len(trimmed),
+			content: trimmed != "" && !strings.HasPrefix(trimmed, "#"),
+		})
+	}
+	return lines
+}
+
+func isSequenceItem(l yamlLine) bool {
+	rest := l.text[l.indent:]
+	return rest == "-" || strings.HasPrefix(rest, "- ")
+}
+
+// mappingKey returns the key on the line and the column it starts at
+func mappingKey(l yamlLine) (string, int, string, bool) {
+	m := yamlKeyLine.FindStringSubmatch(l.text)
+	if m == nil {
+		return "", 0, "", false
+	}
+	return m[3], len(m[1]) + len(m[2]), m[4], true
+}
+
+// lastContent returns the last line in [from, to) that isn't blank or a
+// comment, or from-1 if there is none
+func lastContent(lines []yamlLine, from, to int) int {
+	for i := to - 1; i >= from; i-- {
+		if lines[i].content {
+			return i
+		}
+	}
+	return from - 1
+}
+
+// sequenceItems returns the [start, end) lines of every item of the block
+// sequence at indent within [from, to)
+func sequenceItems(lines []yamlLine, from, to, indent int) [][2]int {
+	items := [][2]int{}
+	for i := from; i < to; i++ {
+		if lines[i].content && lines[i].indent == indent && isSequenceItem(lines[i]) {
+			if len(items) > 0 {
+				items[len(items)-1][1] = i
+			}
+			items = append(items, [2]int{i, to})
+		}
+	}
+	return items
+}
+
+// scrapeConfigSpan locates the parts of one scrape config that Bomb Squad
+// edits
+type scrapeConfigSpan struct {
+	jobName   string
+	start     int
+	end       int
+	keyIndent int
+	// mrcKey is the line of the metric_relabel_configs key, and mrcEnd the
+	// end of its block, or -1 if there is none
+	mrcKey int
+	mrcEnd int
+	// sampleLimit is the line of the sample_limit key, or -1
+	sampleLimit int
+}
+
+// findScrapeConfigs locates every scrape config in a Prometheus config
+func findScrapeConfigs(lines []yamlLine) ([]scrapeConfigSpan, error) {
+	section := -1
+	for i, l := range lines {
+		if key, col, rest, ok := mappingKey(l); ok && col == 0 && key == "scrape_configs" {
+			if strings.TrimSpace(strings.SplitN(rest, "#", 2)[0]) != "" {
+				return nil, fmt.Errorf("scrape_configs isn't a block sequence")
+			}
+			section = i
+		}
+	}
+	if section < 0 {
+		return nil, fmt.Errorf("no scrape_configs found")
+	}
+
+	end, seqIndent := len(lines), -1
+	for i := section + 1; i < len(lines); i++ {
+		l := lines[i]
+		if !l.content {
+			continue
+		}
+		if seqIndent < 0 {
+			seqIndent = l.indent
+		}
+		if l.indent < seqIndent || l.indent == seqIndent && !isSequenceItem(l) {
+			end = i
+			break
+		}
+	}
+
+	spans := []scrapeConfigSpan{}
+	for _, item := range sequenceItems(lines, section+1, end, seqIndent) {
+		span := scrapeConfigSpan{start: item[0], end: item[1], mrcKey: -1, mrcEnd: -1, sampleLimit: -1}
+		_, span.keyIndent, _, _ = mappingKey(lines[item[0]])
+
+		for i := item[0]; i < item[1]; i++ {
+			key, col, rest, ok := mappingKey(lines[i])
+			if !ok || col != span.keyIndent {
+				continue
+			}
+			switch key {
+			case "job_name":
+				v := struct {
+					V string `yaml:"v"`
+				}{}
+				err := yaml.Unmarshal([]byte("v:"+rest), &v)
+				if err != nil {
+					return nil, fmt.Errorf("Couldn't read job_name: %s", err)
+				}
+				span.jobName = v.V
+			case "sample_limit":
+				span.sampleLimit = i
+			case "metric_relabel_configs":
+				span.mrcKey = i
+				span.mrcEnd = item[1]
+				for j := i + 1; j < item[1]; j++ {
+					l := lines[j]
+					if l.content && (l.indent < span.keyIndent || l.indent == span.keyIndent && !isSequenceItem(l)) {
+						span.mrcEnd = j
+						break
+					}
+				}
+			}
+		}
+		spans = append(spans, span)
+	}
+	return spans, nil
+}
+
+// renderSequenceItem renders a relabel config as a block sequence item at
+// the passed indent
+func renderSequenceItem(rc *promcfg.RelabelConfig, indent int) ([]string, error) {
+	b, err := yaml.Marshal(rc)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal relabel config: %s", err)
+	}
+	res := []string{}
+	pad := strings.Repeat(" ", indent)
+	for i, text := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		if i == 0 {
+			res = append(res, pad+"- "+text)
+		} else {
+			res = append(res, pad+"  "+text)
+		}
+	}
+	return res, nil
+}
+
+// editScrapeConfig returns the lines of the scrape config with its metric
+// relabel configs and sample_limit changed to the wanted ones. Relabel
+// configs that are still wanted keep their lines, comments and all; only the
+// unwanted ones are cut out, and new ones are appended.
+func editScrapeConfig(lines []yamlLine, span scrapeConfigSpan, have, want *promcfg.ScrapeConfig) ([]string, error) {
+	pad := strings.Repeat(" ", span.keyIndent)
+
+	// The lines [mrcStart, mrcEnd) get replaced by the edited sequence. With
+	// no sequence to begin with, one is added after the last line of the
+	// scrape config.
+	mrcStart := lastContent(lines, span.start, span.end) + 1
+	mrcEnd, flow := mrcStart, false
+	if span.mrcKey >= 0 {
+		mrcStart, mrcEnd = span.mrcKey, lastContent(lines, span.mrcKey, span.mrcEnd)+1
+		_, _, rest, _ := mappingKey(lines[span.mrcKey])
+		flow = strings.TrimSpace(strings.SplitN(rest, "#", 2)[0]) != ""
+	}
+
+	mrcs := []string{}
+	itemIndent := span.keyIndent
+	if span.mrcKey >= 0 && !flow {
+		for i := span.mrcKey + 1; i < mrcEnd; i++ {
+			if lines[i].content {
+				itemIndent = lines[i].indent
+				break
+			}
+		}
+		items := sequenceItems(lines, span.mrcKey+1, mrcEnd, itemIndent)
+		if len(items) != len(have.MetricRelabelConfigs) {
+			return nil, fmt.Errorf("found %d metric relabel configs in ScrapeConfig %s, expected %d", len(items), span.jobName, len(have.MetricRelabelConfigs))
+		}
+
+		if len(items) > 0 {
+			mrcs = append(mrcs, lineTexts(lines, span.mrcKey+1, items[0][0])...)
+		}
+
+		// Keep the wanted relabel configs in the order they're in. Comments
+		// following an unwanted one are left for whatever comes next.
+		kept := 0
+		for i, rc := range have.MetricRelabelConfigs {
+			if kept < len(want.MetricRelabelConfigs) && SameRelabelConfig(*rc, *want.MetricRelabelConfigs[kept]) {
+				mrcs = append(mrcs, lineTexts(lines, items[i][0], items[i][1])...)
+				kept++
+				continue
+			}
+			mrcs = append(mrcs, lineTexts(lines, lastContent(lines, items[i][0], items[i][1])+1, items[i][1])...)
+		}
+		have = &promcfg.ScrapeConfig{MetricRelabelConfigs: want.MetricRelabelConfigs[:kept]}
+	} else {
+		have = &promcfg.ScrapeConfig{}
+	}
+
+	for _, rc := range want.MetricRelabelConfigs[len(have.MetricRelabelConfigs):] {
+		item, err := renderSequenceItem(rc, itemIndent)
+		if err != nil {
+			return nil, err
+		}
+		mrcs = append(mrcs, item...)
+	}
+	if len(want.MetricRelabelConfigs) > 0 {
+		key := pad + "metric_relabel_configs:"
+		if span.mrcKey >= 0 && !flow {
+			key = lines[span.mrcKey].text
+		}
+		mrcs = append([]string{key}, mrcs...)
+	}
+
+	out := []string{}
+	for i := span.start; i < span.end; i++ {
+		if i == mrcStart {
+			out = append(out, mrcs...)
+		}
+		if i >= mrcStart && i < mrcEnd {
+			continue
+		}
+
+		if i == span.sampleLimit {
+			if want.SampleLimit != 0 {
+				out = append(out, fmt.Sprintf("%ssample_limit: %d", pad, want.SampleLimit))
+			}
+			continue
+		}
+		out = append(out, lines[i].text)
+		if i == span.start && span.sampleLimit < 0 && want.SampleLimit != 0 {
+			out = append(out, fmt.Sprintf("%ssample_limit: %d", pad, want.SampleLimit))
+		}
+	}
+	if mrcStart == span.end {
+		out = append(out, mrcs...)
+	}
+	return out, nil
+}
+
+func lineTexts(lines []yamlLine, from, to int) []string {
+	res := []string{}
+	for i := from; i < to; i++ {
+		res = append(res, lines[i].text)
+	}
+	return res
+}
+
+// EditPromConfig changes the metric relabel configs and sample_limits of the
+// Prometheus config in doc to those of pcfg, by editing just the lines
+// involved. Everything else, comments included, is left byte for byte as it
+// was. It refuses to make any other kind of change.
+func EditPromConfig(doc []byte, pcfg promcfg.Config) ([]byte, error) {
+	current := promcfg.Config{}
+	err := yaml.Unmarshal(doc, &current)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal into prometheus.Config: %s", err)
+	}
+
+	same, err := sameApartFromSuppressions(current, pcfg)
+	if err != nil {
+		return nil, err
+	}
+	if !same {
+		return nil, errBeyondSuppressions
+	}
+
+	lines := splitYAMLLines(doc)
+	spans, err := findScrapeConfigs(lines)
+	if err != nil {
+		return nil, err
+	}
+	if len(spans) != len(current.ScrapeConfigs) {
+		return nil, fmt.Errorf("found %d scrape configs, expected %d", len(spans), len(current.ScrapeConfigs))
+	}
+
+	out := lineTexts(lines, 0, spans[0].start)
+	for i, span := range spans {
+		if span.jobName != current.ScrapeConfigs[i].JobName {
+			return nil, fmt.Errorf("found ScrapeConfig %s, expected %s", span.jobName, current.ScrapeConfigs[i].JobName)
+		}
+		edited, err := editScrapeConfig(lines, span, current.ScrapeConfigs[i], pcfg.ScrapeConfigs[i])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, edited...)
+	}
+	out = append(out, lineTexts(lines, spans[len(spans)-1].end, len(lines))...)
+	b := []byte(strings.Join(out, "\n"))
+
+	// Make sure we ended up where we meant to
+	edited := promcfg.Config{}
+	err = yaml.Unmarshal(b, &edited)
+	if err != nil {
+		return nil, fmt.Errorf("edited config doesn't parse: %s", err)
+	}
+	for i, sc := range edited.ScrapeConfigs {
+		if suppressionsOf(sc) != suppressionsOf(pcfg.ScrapeConfigs[i]) {
+			return nil, fmt.Errorf("edited ScrapeConfig %s doesn't match", sc.JobName)
+		}
+	}
+	return b, nil
+}
+
+// sameApartFromSuppressions reports whether two Prometheus configs differ in
+// nothing but their metric relabel configs and sample_limits
+func sameApartFromSuppressions(a, b promcfg.Config) (bool, error) {
+	strip := func(c promcfg.Config) ([]byte, error) {
+		scrapeConfigs := []*promcfg.ScrapeConfig{}
+		for _, sc := range c.ScrapeConfigs {
+			stripped := *sc
+			stripped.MetricRelabelConfigs = nil
+			stripped.SampleLimit = 0
+			scrapeConfigs = append(scrapeConfigs, &stripped)
+		}
+		c.ScrapeConfigs = scrapeConfigs
+		return yaml.Marshal(c)
+	}
+
+	sa, err := strip(a)
+	if err != nil {
+		return false, err
+	}
+	sb, err := strip(b)
+	if err != nil {
+		return false, err
+	}
+	return string(sa) == string(sb), nil
+}
+
+func suppressionsOf(sc *promcfg.ScrapeConfig) string {
+	s := fmt.Sprintf("sample_limit=%d", sc.SampleLimit)
+	for _, rc := range sc.MetricRelabelConfigs {
+		s += ";" + Fingerprint(*rc)
+	}
+	return s
+}
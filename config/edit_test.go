@@ -0,0 +1,148 @@
+package config_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+var commentedPromConfig = `# Maintained by hand, please keep tidy
+global:
+  scrape_interval: 15s # the default is 1m
+
+scrape_configs:
+  # Prometheus scraping itself
+  - job_name: 'prometheus'
+    static_configs:
+      - targets: ['localhost:9090']
+    metric_relabel_configs:
+      # Nobody looks at these
+      - source_labels: [__name__]
+        regex: go_gc_.*
+        action: drop
+
+  - job_name: "bomb-squad"
+    static_configs:
+      - targets: ['localhost:8080'] # the metrics port
+
+# That's all, folks
+`
+
+func silenceRule(t *testing.T, metric, label string) promcfgpkg.RelabelConfig {
+	mrc, err := config.GenerateMetricRelabelConfig(config.HighCardSeries{MetricName: metric, HighCardLabelName: model.LabelName(label)})
+	require.NoError(t, err)
+	require.NoError(t, prom.ReUnmarshal(&mrc))
+	return mrc
+}
+
+func TestEditPreservesComments(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, []byte(commentedPromConfig))
+
+	mrc := silenceRule(t, "foo", "bar")
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, nil, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+
+	edited := string(c.Data)
+	for _, line := range strings.Split(commentedPromConfig, "\n") {
+		require.Contains(t, edited, line)
+	}
+	require.True(t, strings.HasSuffix(edited, "\n# That's all, folks\n"))
+
+	promConfig, err = config.ReadPromConfig(c)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 2)
+	require.Len(t, promConfig.ScrapeConfigs[1].MetricRelabelConfigs, 1)
+	require.Equal(t, config.Fingerprint(mrc), config.Fingerprint(*promConfig.ScrapeConfigs[1].MetricRelabelConfigs[0]))
+
+	// Taking the silence out again leaves the config as it was
+	promConfig, err = config.RemoveMetricRelabelConfigFromPromConfig([]promcfgpkg.RelabelConfig{mrc}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, commentedPromConfig, string(c.Data))
+}
+
+func TestEditRemovesSilenceWithoutTouchingNeighbours(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, []byte(commentedPromConfig))
+
+	first, second := silenceRule(t, "foo", "bar"), silenceRule(t, "baz", "qux")
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{first, second}, []string{"prometheus"}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Contains(t, string(c.Data), "^foo;")
+
+	promConfig, err = config.RemoveMetricRelabelConfigFromPromConfig([]promcfgpkg.RelabelConfig{first}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+
+	promConfig, err = config.ReadPromConfig(c)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 2)
+	require.Equal(t, config.Fingerprint(second), config.Fingerprint(*promConfig.ScrapeConfigs[0].MetricRelabelConfigs[1]))
+	require.Contains(t, string(c.Data), "      # Nobody looks at these\n")
+	require.NotContains(t, string(c.Data), "^foo;")
+}
+
+func TestEditSampleLimit(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, []byte(commentedPromConfig))
+
+	_, err := config.SetSampleLimit(1000, []string{"bomb-squad"}, c)
+	require.NoError(t, err)
+	require.Contains(t, string(c.Data), "  - job_name: \"bomb-squad\"\n    sample_limit: 1000\n")
+
+	promConfig, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+	promConfig.ScrapeConfigs[1].SampleLimit = 0
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, commentedPromConfig, string(c.Data))
+}
+
+func TestEditFlowSequence(t *testing.T) {
+	doc := "scrape_configs:\n- job_name: prometheus\n  metric_relabel_configs: [] # none yet\n  static_configs:\n  - targets: ['localhost:9090']\n"
+	c := bstesting.NewMemConfigurator(t, []byte(doc))
+
+	mrc := silenceRule(t, "foo", "bar")
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, []string{"prometheus"}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.NotContains(t, string(c.Data), "metric_relabel_configs: []")
+	require.Contains(t, string(c.Data), "  static_configs:\n  - targets: ['localhost:9090']\n")
+
+	promConfig, err = config.ReadPromConfig(c)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 1)
+}
+
+func TestEditRefusesOtherChanges(t *testing.T) {
+	promConfig, err := config.ReadPromConfig(bstesting.NewMemConfigurator(t, []byte(commentedPromConfig)))
+	require.NoError(t, err)
+	promConfig.RuleFiles = append(promConfig.RuleFiles, "/etc/config/bomb-squad/rules.yaml")
+
+	_, err = config.EditPromConfig([]byte(commentedPromConfig), promConfig)
+	require.Error(t, err)
+
+	// Which WritePromConfig handles by rewriting the config whole
+	c := bstesting.NewMemConfigurator(t, []byte(commentedPromConfig))
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.NotContains(t, string(c.Data), "#")
+}
+
+func TestEditKeepsFieldsPrometheusDoesNotKnow(t *testing.T) {
+	// Fields from a newer Prometheus than the one vendored here
+	newerPromConfig := strings.Replace(commentedPromConfig, "  - job_name: \"bomb-squad\"\n", "  - job_name: \"bomb-squad\"\n    enable_compression: false\n", 1) +
+		"storage:\n  tsdb:\n    out_of_order_time_window: 30m\n"
+	c := bstesting.NewMemConfigurator(t, []byte(newerPromConfig))
+
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{silenceRule(t, "foo", "bar")}, nil, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, 1, c.Writes)
+	require.Contains(t, string(c.Data), "    enable_compression: false\n")
+	require.Contains(t, string(c.Data), "    out_of_order_time_window: 30m\n")
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// tmpLabelPrefix starts the names of the temporary labels that multi-rule
+// silences pass values through
+const tmpLabelPrefix = "__tmp_bs_"
+
+// Fingerprint identifies what a relabel config does, rather than how it's
+// written. Fields its action ignores are left out, defaults are filled in,
+// and its regex is compared the way Prometheus anchors and compiles it, so a
+// rule that was reformatted, requoted or had its defaults spelled out still
+// has the same fingerprint.
+func Fingerprint(rc promcfg.RelabelConfig) string {
+	action := rc.Action
+	if action == "" {
+		action = promcfg.RelabelReplace
+	}
+
+	parts := []string{fmt.Sprintf("action=%s", action)}
+	switch action {
+	case promcfg.RelabelLabelDrop, promcfg.RelabelLabelKeep, promcfg.RelabelLabelMap:
+	default:
+		separator := rc.Separator
+		if separator == "" {
+			separator = promcfg.DefaultRelabelConfig.Separator
+		}
+		parts = append(parts,
+			fmt.Sprintf("source_labels=%s", rc.SourceLabels),
+			fmt.Sprintf("separator=%q", separator),
+		)
+	}
+	if action == promcfg.RelabelHashMod {
+		parts = append(parts, fmt.Sprintf("modulus=%d", rc.Modulus))
+	} else {
+		parts = append(parts, fmt.Sprintf("regex=%s", canonicalRegex(rc.Regex)))
+	}
+	if action == promcfg.RelabelReplace || action == promcfg.RelabelHashMod {
+		parts = append(parts, fmt.Sprintf("target_label=%s", rc.TargetLabel))
+	}
+	if action == promcfg.RelabelReplace || action == promcfg.RelabelLabelMap {
+		replacement := rc.Replacement
+		if replacement == "" {
+			replacement = promcfg.DefaultRelabelConfig.Replacement
+		}
+		parts = append(parts, fmt.Sprintf("replacement=%q", replacement))
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// canonicalRegex returns the regex as Prometheus matches it, fully anchored,
+// in a normal form that doesn't depend on redundant anchors or grouping
+func canonicalRegex(re promcfg.Regexp) string {
+	o, _ := re.MarshalYAML()
+	if o == nil {
+		// Unset, so Prometheus uses the default
+		o, _ = promcfg.DefaultRelabelConfig.Regex.MarshalYAML()
+	}
+	s := fmt.Sprint(o)
+
+	s = strings.TrimPrefix(s, "^")
+	if strings.HasSuffix(s, "$") && !strings.HasSuffix(s, `\$`) {
+		s = strings.TrimSuffix(s, "$")
+	}
+
+	parsed, err := syntax.Parse("^(?:"+s+")$", syntax.Perl)
+	if err != nil {
+		return s
+	}
+	return parsed.Simplify().String()
+}
+
+// LooksGenerated reports whether a relabel config carries the marks of one
+// Bomb Squad generated: the bs_silence or bs_bucket_ values it rewrites
+// exploding labels to, or the temporary labels multi-rule silences use.
+// Plain drop and labeldrop rules carry no such mark, and are only known to be
+// Bomb Squad's by a silence recording them.
+func LooksGenerated(rc promcfg.RelabelConfig) bool {
+	if rc.Replacement == silenceValue || strings.HasPrefix(rc.Replacement, "bs_bucket_") {
+		return true
+	}
+	if strings.HasPrefix(rc.TargetLabel, tmpLabelPrefix) {
+		return true
+	}
+	for _, l := range rc.SourceLabels {
+		if strings.HasPrefix(string(l), tmpLabelPrefix) {
+			return true
+		}
+	}
+	if rc.Action == promcfg.RelabelLabelDrop {
+		if o, _ := rc.Regex.MarshalYAML(); o != nil {
+			return strings.HasPrefix(fmt.Sprint(o), tmpLabelPrefix)
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// Garbage is what no longer adds up between the Prometheus config and the
+// Bomb Squad config
+type Garbage struct {
+	// OrphanedRules maps scrape jobs to the relabel configs in them that look
+	// generated by Bomb Squad, but that no silence scoped to the job records
+	OrphanedRules map[string][]promcfg.RelabelConfig
+	// OrphanedSilences are the keys of silences none of whose relabel configs
+	// are left in the scrape configs they're scoped to
+	OrphanedSilences []string
+}
+
+// Empty reports whether there's no garbage
+func (g Garbage) Empty() bool {
+	return len(g.OrphanedRules) == 0 && len(g.OrphanedSilences) == 0
+}
+
+func (g Garbage) String() string {
+	if g.Empty() {
+		return "Nothing to collect"
+	}
+
+	res := ""
+	jobs := []string{}
+	for job := range g.OrphanedRules {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		res += fmt.Sprintf("%d orphaned relabel configs in ScrapeConfig %s\n", len(g.OrphanedRules[job]), job)
+	}
+	for _, key := range g.OrphanedSilences {
+		res += fmt.Sprintf("Silence %s has no relabel configs left in the Prometheus config\n", key)
+	}
+	return res
+}
+
+// FindGarbage looks for Bomb Squad relabel configs in the Prometheus config
+// that no silence accounts for, such as those left behind when state was lost,
+// and for silences whose relabel configs were all taken out by hand. Relabel
+// configs are recognised by their fingerprints and by the marks generated ones
+// carry (see LooksGenerated), so reformatting them doesn't orphan them.
+func FindGarbage(pc, bc Configurator) (Garbage, error) {
+	promConfig, err := ReadPromConfig(pc)
+	if err != nil {
+		return Garbage{}, err
+	}
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return Garbage{}, err
+	}
+
+	g := Garbage{OrphanedRules: map[string][]promcfg.RelabelConfig{}}
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		for _, rc := range scrapeConfig.MetricRelabelConfigs {
+			if !LooksGenerated(*rc) {
+				continue
+			}
+			if !scopesHaveJob(bsCfg.scopesUsingRelabelConfig(*rc), scrapeConfig.JobName) {
+				g.OrphanedRules[scrapeConfig.JobName] = append(g.OrphanedRules[scrapeConfig.JobName], *rc)
+			}
+		}
+	}
+	if len(g.OrphanedRules) == 0 {
+		g.OrphanedRules = nil
+	}
+
+	for key, silence := range bsCfg.Silences {
+		if !silenceInPromConfig(silence, promConfig) {
+			g.OrphanedSilences = append(g.OrphanedSilences, key)
+		}
+	}
+	sort.Strings(g.OrphanedSilences)
+
+	return g, nil
+}
+
+func silenceInPromConfig(silence Silence, promConfig promcfg.Config) bool {
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		if !silence.Scope.HasJob(scrapeConfig.JobName) {
+			continue
+		}
+		for _, rule := range silence.Rules {
+			if FindRelabelConfigInScrapeConfig(rule, *scrapeConfig) >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CollectGarbage removes the orphaned relabel configs and silences that
+// FindGarbage finds, and returns them
+func CollectGarbage(pc, bc Configurator) (Garbage, error) {
+	g, err := FindGarbage(pc, bc)
+	if err != nil || g.Empty() {
+		return g, err
+	}
+
+	if len(g.OrphanedRules) > 0 {
+		promConfig, err := ReadPromConfig(pc)
+		if err != nil {
+			return g, err
+		}
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			for _, rule := range g.OrphanedRules[scrapeConfig.JobName] {
+				i := FindRelabelConfigInScrapeConfig(rule, *scrapeConfig)
+				if i >= 0 {
+					scrapeConfig.MetricRelabelConfigs = DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+				}
+			}
+		}
+		err = WritePromConfig(promConfig, pc)
+		if err != nil {
+			return g, err
+		}
+	}
+
+	if len(g.OrphanedSilences) > 0 {
+		bsCfg, err := ReadBombSquadConfig(bc)
+		if err != nil {
+			return g, err
+		}
+		for _, key := range g.OrphanedSilences {
+			delete(bsCfg.Silences, key)
+		}
+		err = WriteBombSquadConfig(bsCfg, bc)
+		if err != nil {
+			return g, err
+		}
+	}
+
+	return g, nil
+}
@@ -0,0 +1,87 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+// reformattedPromConfig holds the silence of foo.bar as someone might have
+// rewritten it by hand: unanchored, quoted, with defaults spelled out, and
+// keys in another order. It also holds the silence of foo.baz, which Bomb
+// Squad has no record of.
+var reformattedPromConfig = `scrape_configs:
+- job_name: prometheus
+  metric_relabel_configs:
+  - action: replace
+    source_labels: ["__name__", "bar"]
+    separator: ";"
+    regex: 'foo;.*'
+    target_label: bar
+    replacement: "bs_silence"
+  - source_labels: [__name__, baz]
+    regex: ^foo;.*$
+    target_label: baz
+    replacement: bs_silence
+  - source_labels: [__name__]
+    regex: go_gc_.*
+    action: drop
+`
+
+func TestFingerprintIgnoresFormatting(t *testing.T) {
+	promConfig, err := config.ReadPromConfig(bstesting.NewMemConfigurator(t, []byte(reformattedPromConfig)))
+	require.NoError(t, err)
+
+	rcs := promConfig.ScrapeConfigs[0].MetricRelabelConfigs
+	require.Equal(t, config.Fingerprint(silenceRule(t, "foo", "bar")), config.Fingerprint(*rcs[0]))
+	require.NotEqual(t, config.Fingerprint(*rcs[0]), config.Fingerprint(*rcs[1]))
+
+	require.True(t, config.LooksGenerated(*rcs[0]))
+	require.False(t, config.LooksGenerated(*rcs[2]))
+}
+
+func TestUnsilenceReformattedRule(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, []byte(reformattedPromConfig))
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{silenceRule(t, "foo", "bar")}, bc))
+
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	promConfig, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 2)
+}
+
+func TestCollectGarbage(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, []byte(reformattedPromConfig))
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+	for _, label := range []string{"bar", "qux"} {
+		hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: model.LabelName(label)}
+		require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{silenceRule(t, "foo", label)}, bc))
+	}
+
+	g, err := config.FindGarbage(pc, bc)
+	require.NoError(t, err)
+	require.Len(t, g.OrphanedRules["prometheus"], 1)
+	require.Equal(t, "baz", g.OrphanedRules["prometheus"][0].TargetLabel)
+	require.Equal(t, []string{"foo.qux"}, g.OrphanedSilences)
+
+	_, err = config.CollectGarbage(pc, bc)
+	require.NoError(t, err)
+
+	promConfig, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 2)
+	require.Equal(t, "bar", promConfig.ScrapeConfigs[0].MetricRelabelConfigs[0].TargetLabel)
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.bar")
+	require.NotContains(t, b.Silences, "foo.qux")
+
+	g, err = config.FindGarbage(pc, bc)
+	require.NoError(t, err)
+	require.True(t, g.Empty())
+}
@@ -0,0 +1,215 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// EscalationSilence is the first step of every incident: suppressing the
+	// metric's highest-cardinality label
+	EscalationSilence = "silence"
+	// EscalationNextLabel suppresses the next-highest-cardinality label, in
+	// case the first step picked the wrong one
+	EscalationNextLabel = "next_label"
+	// EscalationDropMetric drops the metric altogether
+	EscalationDropMetric = "drop_metric"
+	// EscalationSampleLimit sets sample_limit on the jobs exposing the metric,
+	// so that Prometheus fails their scrapes rather than ingest the explosion
+	EscalationSampleLimit = "sample_limit"
+
+	// IncidentOpen incidents are waiting to see whether their last step worked
+	IncidentOpen = "open"
+	// IncidentContained incidents stopped growing after their last step
+	IncidentContained = "contained"
+	// IncidentExhausted incidents kept growing after every step we have
+	IncidentExhausted = "exhausted"
+)
+
+// Incident follows one exploding metric from its first silence through every
+// escalation it needed to stop growing
+type Incident struct {
+	Status  string         `yaml:"status"`
+	Started time.Time      `yaml:"started"`
+	Steps   []IncidentStep `yaml:"steps"`
+}
+
+// IncidentStep records one action taken against an exploding metric
+type IncidentStep struct {
+	Action string    `yaml:"action"`
+	At     time.Time `yaml:"at"`
+	// Silence is the key of the silence the step created, as shown by `bs list`
+	Silence string `yaml:"silence,omitempty"`
+	// Jobs are the scrape jobs the step applied to
+	Jobs []string `yaml:"jobs,omitempty"`
+	// CardCount is how many series the metric had when the step was taken
+	CardCount float64 `yaml:"card_count"`
+	// PreviousSampleLimits maps each job a sample_limit step changed to its
+	// sample_limit beforehand, so it can be put back
+	PreviousSampleLimits map[string]uint `yaml:"previous_sample_limits,omitempty"`
+	// Skipped, if set, is why the step's action wasn't taken after all
+	Skipped string `yaml:"skipped,omitempty"`
+}
+
+// LastStep returns the most recent step of the incident
+func (i Incident) LastStep() IncidentStep {
+	if len(i.Steps) == 0 {
+		return IncidentStep{}
+	}
+	return i.Steps[len(i.Steps)-1]
+}
+
+// SilencedLabels returns the labels the incident's silences suppressed
+func (i Incident) SilencedLabels() []string {
+	labels := []string{}
+	for _, step := range i.Steps {
+		ml := strings.SplitN(step.Silence, ".", 2)
+		if len(ml) == 2 && (step.Action == EscalationSilence || step.Action == EscalationNextLabel) {
+			labels = append(labels, ml[1])
+		}
+	}
+	return labels
+}
+
+func (i Incident) String() string {
+	actions := []string{}
+	for _, step := range i.Steps {
+		if step.Skipped != "" {
+			actions = append(actions, step.Action+" (skipped)")
+			continue
+		}
+		actions = append(actions, step.Action)
+	}
+	return fmt.Sprintf("%s since %s: %s", i.Status, i.Started.Format(time.RFC3339), strings.Join(actions, " -> "))
+}
+
+// RecordIncidentStep adds the step to the open incident for the metric,
+// starting a new incident if there isn't one
+func RecordIncidentStep(metricName string, step IncidentStep, c Configurator) error {
+	b, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	incident, ok := b.Incidents[metricName]
+	if !ok || incident.Status != IncidentOpen {
+		incident = Incident{Status: IncidentOpen, Started: step.At}
+	}
+	incident.Steps = append(incident.Steps, step)
+	b.Incidents[metricName] = incident
+
+	return WriteBombSquadConfig(b, c)
+}
+
+// SetIncidentStatus updates the status of the incident for the metric
+func SetIncidentStatus(metricName, status string, c Configurator) error {
+	b, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return err
+	}
+
+	incident, ok := b.Incidents[metricName]
+	if !ok {
+		return fmt.Errorf("No incident recorded for metric %s", metricName)
+	}
+	incident.Status = status
+	b.Incidents[metricName] = incident
+
+	return WriteBombSquadConfig(b, c)
+}
+
+// ResolveIncident forgets the incident for the metric, putting back any
+// sample_limit it changed. Silences the incident created are left alone, and
+// are removed with RemoveSilence as usual.
+func ResolveIncident(metricName string, pc, bc Configurator) error {
+	bsCfg, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+
+	incident, ok := bsCfg.Incidents[metricName]
+	if !ok {
+		return fmt.Errorf("No incident recorded for metric %s", metricName)
+	}
+
+	restore := map[string]uint{}
+	for _, step := range incident.Steps {
+		for job, limit := range step.PreviousSampleLimits {
+			restore[job] = limit
+		}
+	}
+
+	if len(restore) > 0 {
+		promConfig, err := ReadPromConfig(pc)
+		if err != nil {
+			return err
+		}
+		for _, scrapeConfig := range promConfig.ScrapeConfigs {
+			if limit, ok := restore[scrapeConfig.JobName]; ok {
+				scrapeConfig.SampleLimit = limit
+				fmt.Printf("Restored sample_limit %d on ScrapeConfig %s\n", limit, scrapeConfig.JobName)
+			}
+		}
+		err = WritePromConfig(promConfig, pc)
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(bsCfg.Incidents, metricName)
+	return WriteBombSquadConfig(bsCfg, bc)
+}
+
+// SetSampleLimit sets sample_limit on the scrape configs of the passed jobs,
+// or every scrape config if jobs is empty. Limits already at or below the new
+// one are left alone. It returns the previous limit of every scrape config it
+// changed.
+func SetSampleLimit(limit uint, jobs []string, c Configurator) (map[string]uint, error) {
+	promConfig, err := ReadPromConfig(c)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := SilenceScope{Jobs: jobs}
+	previous := map[string]uint{}
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		if !scope.HasJob(scrapeConfig.JobName) {
+			continue
+		}
+		if scrapeConfig.SampleLimit != 0 && scrapeConfig.SampleLimit <= limit {
+			continue
+		}
+		previous[scrapeConfig.JobName] = scrapeConfig.SampleLimit
+		scrapeConfig.SampleLimit = limit
+		fmt.Printf("Set sample_limit %d on ScrapeConfig %s\n", limit, scrapeConfig.JobName)
+	}
+
+	if len(previous) == 0 {
+		return previous, nil
+	}
+	return previous, WritePromConfig(promConfig, c)
+}
+
+// ListIncidents prints every incident recorded in the Bomb Squad config
+func ListIncidents(c Configurator) error {
+	b, err := ReadBombSquadConfig(c)
+	if err != nil {
+		return fmt.Errorf("Couldn't list incidents: %s", err)
+	}
+
+	metrics := []string{}
+	for metric := range b.Incidents {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	if len(metrics) > 0 {
+		fmt.Println("Incidents (metricName):")
+	}
+	for _, metric := range metrics {
+		fmt.Printf("%s (%s)\n", metric, b.Incidents[metric])
+	}
+	return nil
+}
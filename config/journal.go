@@ -0,0 +1,253 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"time"
+
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// JournalEntry records a change to the Prometheus config and the Bomb Squad
+// config before either is written, so that if Bomb Squad dies between the two
+// writes the change can be completed, or rolled back, when it starts again.
+// Configs the change doesn't touch are left out.
+type JournalEntry struct {
+	ID        string    `yaml:"id"`
+	Operation string    `yaml:"operation"`
+	Started   time.Time `yaml:"started"`
+	// PromConfig and BSConfig are what the change writes, and the Previous
+	// ones what they replace
+	PromConfig         *string `yaml:"prom_config,omitempty"`
+	PreviousPromConfig string  `yaml:"previous_prom_config,omitempty"`
+	BSConfig           *string `yaml:"bs_config,omitempty"`
+	PreviousBSConfig   string  `yaml:"previous_bs_config,omitempty"`
+}
+
+// journal is what's kept in the journal Configurator: the changes that were
+// started, but not seen through yet
+type journal struct {
+	Entries []JournalEntry `yaml:"entries"`
+}
+
+func readJournal(jc Configurator) (journal, error) {
+	j := journal{}
+	b, err := jc.Read()
+	if err != nil {
+		return j, fmt.Errorf("Failed to read journal: %s", err)
+	}
+	err = yaml.Unmarshal(b, &j)
+	if err != nil {
+		return j, fmt.Errorf("Couldn't unmarshal journal: %s", err)
+	}
+	return j, nil
+}
+
+func writeJournal(j journal, jc Configurator) error {
+	b, err := yaml.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal journal: %s", err)
+	}
+	return jc.Write(b)
+}
+
+func addJournalEntry(e JournalEntry, jc Configurator) error {
+	j, err := readJournal(jc)
+	if err != nil {
+		return err
+	}
+	j.Entries = append(j.Entries, e)
+	return writeJournal(j, jc)
+}
+
+func removeJournalEntry(id string, jc Configurator) error {
+	j, err := readJournal(jc)
+	if err != nil {
+		return err
+	}
+	entries := []JournalEntry{}
+	for _, e := range j.Entries {
+		if e.ID != id {
+			entries = append(entries, e)
+		}
+	}
+	j.Entries = entries
+	return writeJournal(j, jc)
+}
+
+// Journaled runs f against the Prometheus and Bomb Squad configs with their
+// writes held back, then writes each config once: the Prometheus config first,
+// so the Bomb Squad config never records silences that aren't in place. If f
+// fails, or the Prometheus config can't be written, nothing is. With a journal
+// Configurator, the change is recorded there before either config is written,
+// and forgotten once both are.
+func Journaled(operation string, pc, bc, jc Configurator, f func(pc, bc Configurator) error) error {
+	promBatch := NewBatchConfigurator(pc)
+	bsBatch := NewBatchConfigurator(bc)
+
+	err := f(promBatch, bsBatch)
+	if err != nil {
+		return err
+	}
+	if !promBatch.Pending() && !bsBatch.Pending() {
+		return nil
+	}
+
+	var e JournalEntry
+	if jc != nil {
+		e, err = newJournalEntry(operation, promBatch, bsBatch)
+		if err != nil {
+			return err
+		}
+		err = addJournalEntry(e, jc)
+		if err != nil {
+			return fmt.Errorf("Couldn't record %s in journal: %s", operation, err)
+		}
+	}
+
+	err = promBatch.Flush()
+	if err != nil {
+		// Nothing was written, so there's nothing to recover
+		if jc != nil {
+			if jerr := removeJournalEntry(e.ID, jc); jerr != nil {
+				log.Printf("Couldn't remove %s from journal: %s\n", operation, jerr)
+			}
+		}
+		return fmt.Errorf("Couldn't write Prometheus config: %s", err)
+	}
+	err = bsBatch.Flush()
+	if err != nil {
+		// Left in the journal, to be completed by RecoverJournal
+		return fmt.Errorf("Couldn't write Bomb Squad config: %s", err)
+	}
+
+	if jc != nil {
+		err = removeJournalEntry(e.ID, jc)
+		if err != nil {
+			return fmt.Errorf("Couldn't remove %s from journal: %s", operation, err)
+		}
+	}
+	return nil
+}
+
+func newJournalEntry(operation string, promBatch, bsBatch *BatchConfigurator) (JournalEntry, error) {
+	e := JournalEntry{
+		ID:        newID(),
+		Operation: operation,
+		Started:   time.Now().UTC(),
+	}
+	if promBatch.Pending() {
+		previous, err := promBatch.Configurator.Read()
+		if err != nil {
+			return e, fmt.Errorf("Failed to read Prometheus config: %s", err)
+		}
+		b, _ := promBatch.Read()
+		pending := string(b)
+		e.PromConfig, e.PreviousPromConfig = &pending, string(previous)
+	}
+	if bsBatch.Pending() {
+		previous, err := bsBatch.Configurator.Read()
+		if err != nil {
+			return e, fmt.Errorf("Failed to read Bomb Squad config: %s", err)
+		}
+		b, _ := bsBatch.Read()
+		pending := string(b)
+		e.BSConfig, e.PreviousBSConfig = &pending, string(previous)
+	}
+	return e, nil
+}
+
+// RecoverJournal sees through the changes in the journal started more than
+// olderThan ago. A change is completed if one of its writes went through, and
+// dropped if neither did. If its remaining write fails, the one that went
+// through is rolled back. Configs changed by someone else since are left
+// alone, for the reconciler to sort out.
+func RecoverJournal(pc, bc, jc Configurator, olderThan time.Duration) error {
+	j, err := readJournal(jc)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range j.Entries {
+		if time.Since(e.Started) < olderThan {
+			continue
+		}
+		err = recoverJournalEntry(e, pc, bc)
+		if err != nil {
+			return fmt.Errorf("Couldn't recover %s (%s): %s", e.Operation, e.ID, err)
+		}
+		err = removeJournalEntry(e.ID, jc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func recoverJournalEntry(e JournalEntry, pc, bc Configurator) error {
+	curProm, err := pc.Read()
+	if err != nil {
+		return fmt.Errorf("Failed to read Prometheus config: %s", err)
+	}
+	curBS, err := bc.Read()
+	if err != nil {
+		return fmt.Errorf("Failed to read Bomb Squad config: %s", err)
+	}
+
+	promDone := e.PromConfig == nil || samePromConfig(curProm, []byte(*e.PromConfig))
+	promUntouched := e.PromConfig == nil || samePromConfig(curProm, []byte(e.PreviousPromConfig))
+	bsDone := e.BSConfig == nil || bytes.Equal(curBS, []byte(*e.BSConfig))
+	bsUntouched := e.BSConfig == nil || bytes.Equal(curBS, []byte(e.PreviousBSConfig))
+
+	switch {
+	case promDone && bsDone:
+		log.Printf("%s (%s) had already gone through\n", e.Operation, e.ID)
+	case promDone && bsUntouched:
+		err = bc.Write([]byte(*e.BSConfig))
+		if err != nil {
+			log.Printf("Couldn't complete %s (%s), rolling back: %s\n", e.Operation, e.ID, err)
+			return pc.Write([]byte(e.PreviousPromConfig))
+		}
+		log.Printf("Completed %s (%s)\n", e.Operation, e.ID)
+	case bsDone && promUntouched:
+		err = pc.Write([]byte(*e.PromConfig))
+		if err != nil {
+			log.Printf("Couldn't complete %s (%s), rolling back: %s\n", e.Operation, e.ID, err)
+			return bc.Write([]byte(e.PreviousBSConfig))
+		}
+		log.Printf("Completed %s (%s)\n", e.Operation, e.ID)
+	case promUntouched && bsUntouched:
+		log.Printf("Dropped %s (%s), which hadn't been written yet\n", e.Operation, e.ID)
+	default:
+		log.Printf("Configs changed since %s (%s) was started, leaving them as they are\n", e.Operation, e.ID)
+	}
+	return nil
+}
+
+// samePromConfig reports whether two Prometheus configs are the same once
+// parsed, since a Configurator may not read back exactly the bytes written to
+// it (ex. the one for Prometheus Operator monitors)
+func samePromConfig(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	normalize := func(in []byte) ([]byte, error) {
+		cfg := promcfg.Config{}
+		err := yaml.Unmarshal(in, &cfg)
+		if err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(cfg)
+	}
+	na, err := normalize(a)
+	if err != nil {
+		return false
+	}
+	nb, err := normalize(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(na, nb)
+}
@@ -0,0 +1,105 @@
+package config_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+// flakyConfigurator fails writes while broken
+type flakyConfigurator struct {
+	*bstesting.MemConfigurator
+	broken bool
+}
+
+func (c *flakyConfigurator) Write(b []byte) error {
+	if c.broken {
+		return errors.New("API server went away")
+	}
+	return c.MemConfigurator.Write(b)
+}
+
+func silenceJournaled(t *testing.T, pc, bc, jc config.Configurator) error {
+	return config.Journaled("silence foo.bar", pc, bc, jc, func(pc, bc config.Configurator) error {
+		rules := []promcfgpkg.RelabelConfig{silenceRule(t, "foo", "bar")}
+		promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig(rules, nil, pc)
+		if err != nil {
+			return err
+		}
+		err = config.WritePromConfig(promConfig, pc)
+		if err != nil {
+			return err
+		}
+		return config.StoreMetricRelabelConfigBombSquad(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}, config.ReplaceSuppressor{}, rules, bc)
+	})
+}
+
+func TestJournaledWritesBothConfigs(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+	jc := bstesting.NewMemConfigurator(t, []byte{})
+
+	require.NoError(t, silenceJournaled(t, pc, bc, jc))
+	require.Equal(t, 1, pc.Writes)
+	require.Equal(t, 1, bc.Writes)
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.bar")
+
+	// Recorded, then forgotten
+	require.Equal(t, 2, jc.Writes)
+	require.NoError(t, config.RecoverJournal(pc, bc, jc, 0))
+	require.Equal(t, 1, bc.Writes)
+}
+
+func TestRecoverJournalCompletesChange(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := &flakyConfigurator{MemConfigurator: bstesting.NewMemConfigurator(t, []byte{}), broken: true}
+	jc := bstesting.NewMemConfigurator(t, []byte{})
+
+	require.Error(t, silenceJournaled(t, pc, bc, jc))
+	promConfig, err := config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 1)
+	require.Empty(t, bc.Data)
+
+	bc.broken = false
+	require.NoError(t, config.RecoverJournal(pc, bc, jc, 0))
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.bar")
+
+	// Nothing left to recover
+	require.NoError(t, config.RecoverJournal(pc, bc, jc, 0))
+	require.Equal(t, 1, bc.Writes)
+}
+
+func TestRecoverJournalRollsBackChange(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := &flakyConfigurator{MemConfigurator: bstesting.NewMemConfigurator(t, []byte{}), broken: true}
+	jc := bstesting.NewMemConfigurator(t, []byte{})
+
+	require.Error(t, silenceJournaled(t, pc, bc, jc))
+
+	// Still can't write the Bomb Squad config, so the silence comes out again
+	require.NoError(t, config.RecoverJournal(pc, bc, jc, 0))
+	require.Equal(t, string(bstesting.PromConfig()), string(pc.Data))
+}
+
+func TestRemoveSilenceKeepsSilenceIfPromConfigWriteFails(t *testing.T) {
+	pc := &flakyConfigurator{MemConfigurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig())}
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+
+	require.NoError(t, silenceJournaled(t, pc, bc, nil))
+	pc.broken = true
+	require.Error(t, config.RemoveSilence("foo.bar", pc, bc))
+
+	// The rule is still in Prometheus, so the silence must stay on record
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.bar")
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log"
+
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// legacyBombSquadConfig is how Bomb Squad configs without a Version recorded
+// silences: base64-encoded relabel configs, with what little else was known
+// about them in maps alongside
+type legacyBombSquadConfig struct {
+	SuppressedMetrics     map[string]map[string]string `yaml:"SuppressedMetrics"`
+	SuppressedLabelNames  map[string]map[string]string `yaml:"SuppressedLabelNames"`
+	SuppressedMetricNames map[string]string            `yaml:"SuppressedMetricNames"`
+	Scopes                map[string]SilenceScope      `yaml:"Scopes"`
+	Strategies            map[string]string            `yaml:"Strategies"`
+	ExtraRules            map[string][]string          `yaml:"ExtraRules"`
+	KeptValues            map[string][]string          `yaml:"KeptValues"`
+}
+
+// migrateBombSquadConfig turns the silences of a config without a Version
+// into Silence records. Nothing recorded when or why they were put in place,
+// but only the patrol made silences back then.
+func migrateBombSquadConfig(b []byte, bscfg *BombSquadConfig) error {
+	legacy := legacyBombSquadConfig{}
+	err := yaml.Unmarshal(b, &legacy)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal unversioned Bomb Squad config: %s", err)
+	}
+
+	add := func(s Silence, encoded string, defaultStrategy string) error {
+		key := s.Key()
+		for _, e := range append([]string{encoded}, legacy.ExtraRules[key]...) {
+			rc, err := decodeRelabelConfig(e)
+			if err != nil {
+				return fmt.Errorf("Couldn't migrate silence %s: %s", key, err)
+			}
+			s.Rules = append(s.Rules, rc)
+		}
+		s.Origin = OriginAuto
+		s.Strategy = legacy.Strategies[key]
+		if s.Strategy == "" {
+			s.Strategy = defaultStrategy
+		}
+		s.Scope = legacy.Scopes[key]
+		s.KeptValues = legacy.KeptValues[key]
+		bscfg.putSilence(s)
+		return nil
+	}
+
+	for metric, labels := range legacy.SuppressedMetrics {
+		for label, encoded := range labels {
+			err = add(Silence{Kind: SilenceLabelValues, Metric: metric, Label: label}, encoded, StrategyReplace)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for metric, patterns := range legacy.SuppressedLabelNames {
+		for pattern, encoded := range patterns {
+			err = add(Silence{Kind: SilenceLabelNames, Metric: metric, Label: pattern}, encoded, StrategyLabelDrop)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for pattern, encoded := range legacy.SuppressedMetricNames {
+		err = add(Silence{Kind: SilenceMetricNames, Metric: pattern}, encoded, StrategyDrop)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(bscfg.Silences) > 0 {
+		log.Printf("Migrated %d silences to Bomb Squad config schema version %d\n", len(bscfg.Silences), SchemaVersion)
+	}
+	bscfg.Version = SchemaVersion
+	return nil
+}
+
+func decodeRelabelConfig(encoded string) (promcfg.RelabelConfig, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't decode relabel config: %s", err)
+	}
+
+	rc := promcfg.RelabelConfig{}
+	err = yaml.Unmarshal(b, &rc)
+	if err != nil {
+		return promcfg.RelabelConfig{}, fmt.Errorf("Couldn't unmarshal relabel config: %s", err)
+	}
+	return rc, nil
+}
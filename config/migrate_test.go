@@ -0,0 +1,95 @@
+package config_test
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func legacyRule(t *testing.T, rc promcfgpkg.RelabelConfig) string {
+	b, err := yaml.Marshal(rc)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestMigratesUnversionedBombSquadConfig(t *testing.T) {
+	mrc := silenceRule(t, "foo", "bar")
+	drop, err := config.GenerateMetricNameDropRelabelConfig(config.ExplodingMetricNames{Pattern: "tmp_.*"})
+	require.NoError(t, err)
+
+	legacy := `SuppressedMetrics:
+  foo:
+    bar: ` + legacyRule(t, mrc) + `
+SuppressedMetricNames:
+  tmp_.*: ` + legacyRule(t, drop) + `
+Scopes:
+  foo.bar:
+    jobs:
+    - prometheus
+Strategies:
+  foo.bar: replace
+TopKValues: 3
+`
+	bc := bstesting.NewMemConfigurator(t, []byte(legacy))
+
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Equal(t, config.SchemaVersion, b.Version)
+	require.Equal(t, 3, b.TopKValues)
+	require.Len(t, b.Silences, 2)
+
+	silence := b.Silences["foo.bar"]
+	require.Equal(t, config.SilenceLabelValues, silence.Kind)
+	require.Equal(t, "foo", silence.Metric)
+	require.Equal(t, "bar", silence.Label)
+	require.Equal(t, config.OriginAuto, silence.Origin)
+	require.Equal(t, config.StrategyReplace, silence.Strategy)
+	require.Equal(t, []string{"prometheus"}, silence.Scope.Jobs)
+	require.True(t, silence.UsesRule(mrc))
+	require.NotEmpty(t, silence.ID)
+
+	require.Equal(t, config.SilenceMetricNames, b.Silences["tmp_.*"].Kind)
+	require.Equal(t, config.StrategyDrop, b.Silences["tmp_.*"].Strategy)
+
+	// Once written, the config is in the new schema, and reads the same
+	require.NoError(t, config.WriteBombSquadConfig(b, bc))
+	require.NotContains(t, string(bc.Data), "SuppressedMetrics")
+	again, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Equal(t, b.Silences["foo.bar"].ID, again.Silences["foo.bar"].ID)
+	require.True(t, again.Silences["foo.bar"].UsesRule(mrc))
+}
+
+func TestRefusesNewerBombSquadConfig(t *testing.T) {
+	_, err := config.ReadBombSquadConfig(bstesting.NewMemConfigurator(t, []byte("Version: 99\n")))
+	require.Error(t, err)
+}
+
+func TestRemoveSilenceByID(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+
+	mrc := silenceRule(t, "foo", "bar")
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, nil, pc)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, pc))
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{mrc}, bc))
+
+	b, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.NoError(t, config.RemoveSilence(b.Silences["foo.bar"].ID, pc, bc))
+
+	b, err = config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Empty(t, b.Silences)
+	promConfig, err = config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	for _, sc := range promConfig.ScrapeConfigs {
+		require.Empty(t, sc.MetricRelabelConfigs)
+	}
+}
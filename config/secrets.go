@@ -0,0 +1,110 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// redactedSecret is what Prometheus's config types marshal secrets as, such
+// as basic auth passwords and bearer tokens
+const redactedSecret = "<secret>"
+
+// restoreSecrets replaces the redacted secrets in a rendered Prometheus config
+// with their values in the original document. Writing the placeholders back
+// would break scraping or remote write, so a secret that can't be found in
+// the original is an error.
+func restoreSecrets(rendered, original []byte) ([]byte, error) {
+	if !bytes.Contains(rendered, []byte(redactedSecret)) {
+		return rendered, nil
+	}
+
+	var r, o yaml.MapSlice
+	err := yaml.Unmarshal(rendered, &r)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal rendered Prometheus config: %s", err)
+	}
+	err = yaml.Unmarshal(original, &o)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal original Prometheus config: %s", err)
+	}
+
+	merged, err := mergeSecrets(r, o, "")
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(merged)
+}
+
+// mergeSecrets walks the rendered document, looking up every redacted secret
+// at the same place in the original one
+func mergeSecrets(rendered, original interface{}, path string) (interface{}, error) {
+	var err error
+	switch r := rendered.(type) {
+	case string:
+		if r != redactedSecret {
+			return r, nil
+		}
+		if o, ok := original.(string); ok {
+			return o, nil
+		}
+		return nil, fmt.Errorf("No secret to restore at %s", path)
+
+	case yaml.MapSlice:
+		o, _ := original.(yaml.MapSlice)
+		for i, item := range r {
+			r[i].Value, err = mergeSecrets(item.Value, mapSliceValue(o, item.Key), fmt.Sprintf("%s.%v", path, item.Key))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+	case []interface{}:
+		o, _ := original.([]interface{})
+		for i, item := range r {
+			r[i], err = mergeSecrets(item, matchingItem(o, item, i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rendered, nil
+}
+
+func mapSliceValue(m yaml.MapSlice, key interface{}) interface{} {
+	for _, item := range m {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// identifyingKeys are the keys that tell apart the items of the sequences in
+// a Prometheus config, such as scrape configs and remote writes
+var identifyingKeys = []string{"job_name", "url", "name"}
+
+// matchingItem returns the item of the original sequence that corresponds to
+// the rendered one, going by its identifying key if it has one, and by its
+// position otherwise
+func matchingItem(original []interface{}, item interface{}, i int) interface{} {
+	if m, ok := item.(yaml.MapSlice); ok {
+		for _, key := range identifyingKeys {
+			id, ok := mapSliceValue(m, key).(string)
+			if !ok {
+				continue
+			}
+			for _, o := range original {
+				if om, ok := o.(yaml.MapSlice); ok && mapSliceValue(om, key) == id {
+					return o
+				}
+			}
+			return nil
+		}
+	}
+	if i < len(original) {
+		return original[i]
+	}
+	return nil
+}
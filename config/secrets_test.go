@@ -0,0 +1,65 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func requireSecrets(t *testing.T, c *bstesting.MemConfigurator) {
+	require.NotContains(t, string(c.Data), "<secret>")
+
+	promConfig, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+	require.Equal(t, "hunter2", string(promConfig.RemoteWriteConfigs[0].HTTPClientConfig.BasicAuth.Password))
+	for _, sc := range promConfig.ScrapeConfigs {
+		switch sc.JobName {
+		case "federate":
+			require.Equal(t, "s3cr3t-t0k3n", string(sc.HTTPClientConfig.BearerToken))
+		case "node":
+			require.Equal(t, "n0d3-p4ss", string(sc.HTTPClientConfig.BasicAuth.Password))
+		}
+	}
+}
+
+func TestSilencingKeepsSecrets(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, bstesting.PromConfigWithSecrets())
+
+	mrc := silenceRule(t, "foo", "bar")
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfgpkg.RelabelConfig{mrc}, nil, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	requireSecrets(t, c)
+
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}, config.ReplaceSuppressor{}, []promcfgpkg.RelabelConfig{mrc}, bc))
+	require.NoError(t, config.RemoveSilence("foo.bar", c, bc))
+	require.Equal(t, string(bstesting.PromConfigWithSecrets()), string(c.Data))
+}
+
+func TestRewritingKeepsSecrets(t *testing.T) {
+	c := bstesting.NewMemConfigurator(t, bstesting.PromConfigWithSecrets())
+
+	promConfig, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+
+	// Neither of which can be done by editing in place
+	promConfig.RuleFiles = append(promConfig.RuleFiles, "/etc/config/bomb-squad/rules.yaml")
+	sc := promConfig.ScrapeConfigs
+	promConfig.ScrapeConfigs = []*promcfgpkg.ScrapeConfig{sc[2], sc[0], sc[1]}
+
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	requireSecrets(t, c)
+}
+
+func TestRefusesToWriteRedactedSecrets(t *testing.T) {
+	promConfig, err := config.ReadPromConfig(bstesting.NewMemConfigurator(t, bstesting.PromConfigWithSecrets()))
+	require.NoError(t, err)
+
+	c := bstesting.NewMemConfigurator(t, []byte{})
+	require.Error(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, 0, c.Writes)
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// SchemaVersion is the version of the Bomb Squad config schema this build
+// writes
+const SchemaVersion = 2
+
+// The kinds of silence
+const (
+	// SilenceLabelValues suppresses the values of an exploding label on a
+	// metric
+	SilenceLabelValues = "label_values"
+	// SilenceLabelNames drops label names matching a pattern
+	SilenceLabelNames = "label_names"
+	// SilenceMetricNames drops metric names matching a pattern
+	SilenceMetricNames = "metric_names"
+)
+
+// Where silences come from
+const (
+	// OriginAuto silences were put in place by the patrol
+	OriginAuto = "auto"
+	// OriginManual silences were asked for from the command line
+	OriginManual = "manual"
+	// OriginPropagated silences were put in place for another Prometheus,
+	// ex. another shard, and copied here
+	OriginPropagated = "propagated"
+)
+
+// sampleValuesKept is how many offending values a silence records
+const sampleValuesKept = 5
+
+// Silence records a silence: what it suppresses, why it was put in place,
+// and the metric relabel configs it added to the Prometheus config
+type Silence struct {
+	ID   string `yaml:"id"`
+	Kind string `yaml:"kind"`
+	// Metric is the exploding metric or, for metric name silences, the
+	// pattern matching the exploding metric names
+	Metric string `yaml:"metric"`
+	// Label is the exploding label or, for label name silences, the pattern
+	// matching the exploding label names
+	Label string `yaml:"label,omitempty"`
+	// Created is zero for silences migrated from configs that didn't record it
+	Created  time.Time `yaml:"created"`
+	Origin   string    `yaml:"origin"`
+	Strategy string    `yaml:"strategy"`
+	// Cardinality is how many label values, label names or metric names were
+	// seen when the silence was put in place, and SampleValues are some of
+	// them
+	Cardinality  int          `yaml:"cardinality,omitempty"`
+	SampleValues []string     `yaml:"sample_values,omitempty"`
+	Scope        SilenceScope `yaml:"scope,omitempty"`
+	// KeptValues are the values a topk silence keeps
+	KeptValues []string                `yaml:"kept_values,omitempty"`
+	Rules      []promcfg.RelabelConfig `yaml:"rules"`
+}
+
+// Key returns the key of the silence in the Bomb Squad config, as shown by
+// `bs list`
+func (s Silence) Key() string {
+	if s.Kind == SilenceMetricNames {
+		return s.Metric
+	}
+	return fmt.Sprintf("%s.%s", s.Metric, s.Label)
+}
+
+func (s Silence) String() string {
+	strategy := s.Strategy
+	if len(s.KeptValues) > 0 {
+		strategy += fmt.Sprintf(", keeping %s", strings.Join(s.KeptValues, ", "))
+	}
+
+	created := "unknown"
+	if !s.Created.IsZero() {
+		created = s.Created.Format(time.RFC3339)
+	}
+
+	res := fmt.Sprintf("%s [%s] (strategy: %s; %s; %s, created %s", s.Key(), s.ID, strategy, s.Scope, s.Origin, created)
+	if s.Cardinality > 0 {
+		res += fmt.Sprintf(", cardinality %d", s.Cardinality)
+	}
+	if len(s.SampleValues) > 0 {
+		res += fmt.Sprintf(", ex. %s", strings.Join(s.SampleValues, ", "))
+	}
+	return res + ")"
+}
+
+// UsesRule reports whether the relabel config is one of the silence's
+func (s Silence) UsesRule(rc promcfg.RelabelConfig) bool {
+	for _, rule := range s.Rules {
+		if SameRelabelConfig(rule, rc) {
+			return true
+		}
+	}
+	return false
+}
+
+// SameRelabelConfig reports whether two relabel configs do the same thing,
+// however they happen to be written
+func SameRelabelConfig(a, b promcfg.RelabelConfig) bool {
+	return Fingerprint(a) == Fingerprint(b)
+}
+
+// putSilence records the silence under its key. Replacing a silence, ex. to
+// widen its scope, keeps the ID, creation time and origin of the original.
+func (b *BombSquadConfig) putSilence(s Silence) {
+	if old, ok := b.Silences[s.Key()]; ok {
+		s.ID, s.Created, s.Origin = old.ID, old.Created, old.Origin
+	}
+	if s.ID == "" {
+		s.ID = newID()
+	}
+	if s.Origin == "" {
+		s.Origin = OriginAuto
+	}
+	b.Silences[s.Key()] = s
+}
+
+// AdoptSilence puts a silence made for another Prometheus in place in this
+// one too, in the same jobs if it has them, and records it as propagated
+func AdoptSilence(s Silence, pc, bc Configurator) error {
+	promConfig, jobs, err := InsertMetricRelabelConfigToPromConfig(s.Rules, s.Scope.Jobs, pc)
+	if err != nil {
+		return fmt.Errorf("Error inserting relabel config: %s", err)
+	}
+	err = WritePromConfig(promConfig, pc)
+	if err != nil {
+		return err
+	}
+
+	b, err := ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+	s.Origin = OriginPropagated
+	s.Scope.Jobs = jobs
+	b.putSilence(s)
+	return WriteBombSquadConfig(b, bc)
+}
+
+// FindSilence looks up a silence by its key or its ID
+func (b BombSquadConfig) FindSilence(keyOrID string) (Silence, bool) {
+	if s, ok := b.Silences[keyOrID]; ok {
+		return s, true
+	}
+	for _, s := range b.Silences {
+		if s.ID == keyOrID {
+			return s, true
+		}
+	}
+	return Silence{}, false
+}
+
+// sortedSilences returns the silences of a kind, ordered by key
+func (b BombSquadConfig) sortedSilences(kind string) []Silence {
+	res := []Silence{}
+	for _, s := range b.Silences {
+		if s.Kind == kind {
+			res = append(res, s)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key() < res[j].Key() })
+	return res
+}
+
+// newID returns a short random ID
+func newID() string {
+	b := make([]byte, 4)
+	_, err := rand.Read(b)
+	if err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+// sampleValues returns the values seen on the most series, most first
+func sampleValues(counts map[string]int) []string {
+	values := []string{}
+	for v := range counts {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if counts[values[i]] != counts[values[j]] {
+			return counts[values[i]] > counts[values[j]]
+		}
+		return values[i] < values[j]
+	})
+	return firstValues(values)
+}
+
+// firstValues returns the first few of the sorted values
+func firstValues(values []string) []string {
+	if len(values) > sampleValuesKept {
+		values = values[:sampleValuesKept]
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
@@ -0,0 +1,309 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+const (
+	// StrategyReplace rewrites the exploding label's value to bs_silence
+	StrategyReplace = "replace"
+	// StrategyDrop drops the exploding series entirely
+	StrategyDrop = "drop"
+	// StrategyLabelDrop drops the exploding label. Prometheus applies labeldrop
+	// to every series in a scrape config, regardless of metric name.
+	StrategyLabelDrop = "labeldrop"
+	// StrategyHashMod buckets the exploding label's values into a fixed number
+	// of values, so that rate and sum queries still work
+	StrategyHashMod = "hashmod"
+	// StrategyTopK keeps the most common values of the exploding label and
+	// rewrites the long tail to a single value
+	StrategyTopK = "topk"
+
+	// DefaultHashModBuckets is how many buckets the hashmod strategy keeps
+	// unless told otherwise
+	DefaultHashModBuckets = 16
+	// DefaultTopKValues is how many values the topk strategy keeps unless
+	// told otherwise
+	DefaultTopKValues = 10
+	// DefaultOtherValue is what the topk strategy rewrites the long tail to
+	// unless told otherwise
+	DefaultOtherValue = "other"
+)
+
+// Suppressor turns an exploding series into the metric relabel configs that
+// suppress it. Relabel configs are applied in order.
+type Suppressor interface {
+	Strategy() string
+	RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error)
+}
+
+// NewSuppressor returns the Suppressor for the named strategy, with any
+// settings it needs taken from the Bomb Squad config
+func NewSuppressor(strategy string, b BombSquadConfig) (Suppressor, error) {
+	switch strategy {
+	case "", StrategyReplace:
+		return ReplaceSuppressor{}, nil
+	case StrategyDrop:
+		return DropSuppressor{}, nil
+	case StrategyLabelDrop:
+		return LabelDropSuppressor{}, nil
+	case StrategyHashMod:
+		buckets := b.HashModBuckets
+		if buckets <= 0 {
+			buckets = DefaultHashModBuckets
+		}
+		return HashModSuppressor{Buckets: buckets}, nil
+	case StrategyTopK:
+		k, other := b.TopKValues, b.OtherValue
+		if k <= 0 {
+			k = DefaultTopKValues
+		}
+		if other == "" {
+			other = DefaultOtherValue
+		}
+		return TopKSuppressor{K: k, Other: other}, nil
+	}
+	return nil, fmt.Errorf("Unknown suppression strategy '%s'", strategy)
+}
+
+// SuppressorFor returns the Suppressor configured for the metric, falling
+// back to the global strategy and then to replace
+func (b BombSquadConfig) SuppressorFor(metricName string) (Suppressor, error) {
+	strategy := b.Strategy
+	if s, ok := b.MetricStrategies[metricName]; ok {
+		strategy = s
+	}
+	return NewSuppressor(strategy, b)
+}
+
+// ReplaceSuppressor flattens the exploding label to a single value
+type ReplaceSuppressor struct{}
+
+// Strategy implements Suppressor
+func (ReplaceSuppressor) Strategy() string {
+	return StrategyReplace
+}
+
+// RelabelConfigs implements Suppressor
+func (ReplaceSuppressor) RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error) {
+	rc, err := GenerateMetricRelabelConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	return []promcfg.RelabelConfig{rc}, nil
+}
+
+// DropSuppressor drops every series of the metric within the silence's scope
+type DropSuppressor struct{}
+
+// Strategy implements Suppressor
+func (DropSuppressor) Strategy() string {
+	return StrategyDrop
+}
+
+// RelabelConfigs implements Suppressor
+func (DropSuppressor) RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error) {
+	rc, err := GenerateMetricRelabelConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	return []promcfg.RelabelConfig{{
+		SourceLabels: rc.SourceLabels,
+		Regex:        rc.Regex,
+		Action:       promcfg.RelabelDrop,
+	}}, nil
+}
+
+// LabelDropSuppressor drops the exploding label from every series scraped
+// by the silenced jobs
+type LabelDropSuppressor struct{}
+
+// Strategy implements Suppressor
+func (LabelDropSuppressor) Strategy() string {
+	return StrategyLabelDrop
+}
+
+// RelabelConfigs implements Suppressor
+func (LabelDropSuppressor) RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error) {
+	rc, err := GenerateLabelDropRelabelConfig(ExplodingLabelNames{
+		MetricName: s.MetricName,
+		// Label names can't contain anything that needs quoting
+		Pattern: string(s.HighCardLabelName),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []promcfg.RelabelConfig{rc}, nil
+}
+
+// HashModSuppressor keeps a bounded number of values for the exploding label
+// by hashing them into Buckets buckets. The hash lands in a temporary label
+// unique to the silence, gets copied over the exploding label for matching
+// series, and is then dropped again.
+type HashModSuppressor struct {
+	Buckets int
+}
+
+// Strategy implements Suppressor
+func (HashModSuppressor) Strategy() string {
+	return StrategyHashMod
+}
+
+// invalidLabelNameChars are the characters metric names allow but label names
+// don't
+var invalidLabelNameChars = regexp.MustCompile("[^a-zA-Z0-9_]")
+
+// tmpLabelName returns the name of the temporary label a silence passes values
+// through. Metric names can contain colons, ex. those recorded by rules, but
+// label names can't, so they're replaced, and a short hash of the metric name
+// keeps it apart from one that only differs by them.
+func tmpLabelName(kind string, s HighCardSeries) string {
+	metricName := invalidLabelNameChars.ReplaceAllString(s.MetricName, "_")
+	if metricName != s.MetricName {
+		sum := sha256.Sum256([]byte(s.MetricName))
+		metricName += "_" + hex.EncodeToString(sum[:4])
+	}
+	return fmt.Sprintf("%s%s_%s_%s", tmpLabelPrefix, kind, metricName, s.HighCardLabelName)
+}
+
+// RelabelConfigs implements Suppressor
+func (h HashModSuppressor) RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error) {
+	tmpLabel := tmpLabelName("hash", s)
+
+	// The exploding label must be present to get bucketed
+	sourceLabels, regexpPrefix := scopedMatch(s)
+	regexpOriginal := regexpPrefix + ".+;([0-9]+)$"
+	promRegex, err := promcfg.NewRegexp(regexpOriginal)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", regexpOriginal, err)
+	}
+
+	// Label names can't contain anything that needs quoting
+	tmpRegex, err := promcfg.NewRegexp(tmpLabel)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", tmpLabel, err)
+	}
+
+	return []promcfg.RelabelConfig{
+		{
+			SourceLabels: model.LabelNames{s.HighCardLabelName},
+			Modulus:      uint64(h.Buckets),
+			TargetLabel:  tmpLabel,
+			Action:       promcfg.RelabelHashMod,
+		},
+		{
+			SourceLabels: append(sourceLabels, s.HighCardLabelName, model.LabelName(tmpLabel)),
+			Regex:        promRegex,
+			TargetLabel:  string(s.HighCardLabelName),
+			Replacement:  "bs_bucket_$1",
+			Action:       promcfg.RelabelReplace,
+		},
+		{
+			Regex:  tmpRegex,
+			Action: promcfg.RelabelLabelDrop,
+		},
+	}, nil
+}
+
+// TopKSuppressor keeps the K most common values of the exploding label, going
+// by how many series carry each, and rewrites all others to Other
+type TopKSuppressor struct {
+	K     int
+	Other string
+}
+
+// Strategy implements Suppressor
+func (TopKSuppressor) Strategy() string {
+	return StrategyTopK
+}
+
+// Keep returns the values of the exploding label that the silence keeps. The
+// Other value, and whatever earlier silences rewrote values to, don't count.
+func (t TopKSuppressor) Keep(s HighCardSeries) []string {
+	values := []string{}
+	for v := range s.ValueCounts {
+		if v != t.Other && v != silenceValue && v != "" {
+			values = append(values, v)
+		}
+	}
+
+	// Most series first, ties broken by value so the kept set is stable
+	sort.Slice(values, func(i, j int) bool {
+		ci, cj := s.ValueCounts[values[i]], s.ValueCounts[values[j]]
+		if ci != cj {
+			return ci > cj
+		}
+		return values[i] < values[j]
+	})
+
+	if len(values) > t.K {
+		values = values[:t.K]
+	}
+	sort.Strings(values)
+	return values
+}
+
+// RelabelConfigs implements Suppressor. Kept values are copied to a temporary
+// label unique to the silence, anything that didn't get copied is rewritten
+// to Other, and the temporary label is dropped again.
+func (t TopKSuppressor) RelabelConfigs(s HighCardSeries) ([]promcfg.RelabelConfig, error) {
+	keep := t.Keep(s)
+	if len(keep) == 0 {
+		return nil, fmt.Errorf("No values of label %s to keep for metric %s", s.HighCardLabelName, s.MetricName)
+	}
+
+	tmpLabel := tmpLabelName("keep", s)
+	sourceLabels, regexpPrefix := scopedMatch(s)
+
+	quoted := []string{}
+	for _, v := range keep {
+		quoted = append(quoted, regexp.QuoteMeta(v))
+	}
+	keepRegexpOriginal := fmt.Sprintf("%s(%s)$", regexpPrefix, strings.Join(quoted, "|"))
+	keepRegex, err := promcfg.NewRegexp(keepRegexpOriginal)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", keepRegexpOriginal, err)
+	}
+
+	// The exploding label must be present, and not have been kept
+	otherRegexpOriginal := regexpPrefix + ".+;$"
+	otherRegex, err := promcfg.NewRegexp(otherRegexpOriginal)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", otherRegexpOriginal, err)
+	}
+
+	// Label names can't contain anything that needs quoting
+	tmpRegex, err := promcfg.NewRegexp(tmpLabel)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create promcfg.Regexp from '%s': %s", tmpLabel, err)
+	}
+
+	return []promcfg.RelabelConfig{
+		{
+			SourceLabels: append(append(model.LabelNames{}, sourceLabels...), s.HighCardLabelName),
+			Regex:        keepRegex,
+			TargetLabel:  tmpLabel,
+			Replacement:  "$1",
+			Action:       promcfg.RelabelReplace,
+		},
+		{
+			SourceLabels: append(sourceLabels, s.HighCardLabelName, model.LabelName(tmpLabel)),
+			Regex:        otherRegex,
+			TargetLabel:  string(s.HighCardLabelName),
+			Replacement:  t.Other,
+			Action:       promcfg.RelabelReplace,
+		},
+		{
+			Regex:  tmpRegex,
+			Action: promcfg.RelabelLabelDrop,
+		},
+	}, nil
+}
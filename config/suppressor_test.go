@@ -0,0 +1,152 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfgpkg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuppressorFor(t *testing.T) {
+	b := config.BombSquadConfig{
+		Strategy:         config.StrategyDrop,
+		MetricStrategies: map[string]string{"foo": config.StrategyHashMod},
+		HashModBuckets:   8,
+	}
+
+	s, err := b.SuppressorFor("foo")
+	require.NoError(t, err)
+	require.Equal(t, config.HashModSuppressor{Buckets: 8}, s)
+
+	s, err = b.SuppressorFor("bar")
+	require.NoError(t, err)
+	require.Equal(t, config.StrategyDrop, s.Strategy())
+
+	s, err = config.BombSquadConfig{}.SuppressorFor("bar")
+	require.NoError(t, err)
+	require.Equal(t, config.StrategyReplace, s.Strategy())
+
+	_, err = config.NewSuppressor("explode", config.BombSquadConfig{})
+	require.Error(t, err)
+}
+
+func TestSuppressorsGenerateValidRelabelConfigs(t *testing.T) {
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"}
+	hcs.ValueCounts = map[string]int{"a": 3, "b": 1}
+	for _, strategy := range []string{config.StrategyReplace, config.StrategyDrop, config.StrategyLabelDrop, config.StrategyHashMod, config.StrategyTopK} {
+		s, err := config.NewSuppressor(strategy, config.BombSquadConfig{})
+		require.NoError(t, err)
+		mrcs, err := s.RelabelConfigs(hcs)
+		require.NoError(t, err)
+		require.NotEmpty(t, mrcs)
+		for i := range mrcs {
+			require.NoError(t, prom.ReUnmarshal(&mrcs[i]), strategy)
+		}
+	}
+}
+
+func TestHashModSuppressorBucketsValues(t *testing.T) {
+	s := config.HashModSuppressor{Buckets: 4}
+	mrcs, err := s.RelabelConfigs(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"})
+	require.NoError(t, err)
+	require.Len(t, mrcs, 3)
+
+	require.Equal(t, promcfgpkg.RelabelHashMod, mrcs[0].Action)
+	require.Equal(t, uint64(4), mrcs[0].Modulus)
+	require.Equal(t, "__tmp_bs_hash_foo_bar", mrcs[0].TargetLabel)
+
+	require.Equal(t, "__name__, bar, __tmp_bs_hash_foo_bar", mrcs[1].SourceLabels.String())
+	require.Equal(t, "bs_bucket_3", mrcs[1].Regex.ReplaceAllString("foo;abc123;3", mrcs[1].Replacement))
+	require.False(t, mrcs[1].Regex.MatchString("foo;;3"))
+	require.False(t, mrcs[1].Regex.MatchString("other;abc123;3"))
+
+	require.Equal(t, promcfgpkg.RelabelLabelDrop, mrcs[2].Action)
+	require.True(t, mrcs[2].Regex.MatchString("__tmp_bs_hash_foo_bar"))
+}
+
+func TestHashModSuppressorHandlesRecordedMetricNames(t *testing.T) {
+	s := config.HashModSuppressor{Buckets: 4}
+	mrcs, err := s.RelabelConfigs(config.HighCardSeries{MetricName: "job:requests:rate5m", HighCardLabelName: "bar"})
+	require.NoError(t, err)
+	for i := range mrcs {
+		require.NoError(t, prom.ReUnmarshal(&mrcs[i]))
+	}
+	require.Regexp(t, "^__tmp_bs_hash_job_requests_rate5m_[0-9a-f]{8}_bar$", mrcs[0].TargetLabel)
+	require.True(t, model.LabelName(mrcs[0].TargetLabel).IsValid())
+}
+
+func TestCanRemoveMultiRuleSilence(t *testing.T) {
+	pc := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bc := bstesting.NewMemConfigurator(t, []byte{})
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+	mrcs, err := config.HashModSuppressor{Buckets: 4}.RelabelConfigs(hcs)
+	require.NoError(t, err)
+	for i := range mrcs {
+		require.NoError(t, prom.ReUnmarshal(&mrcs[i]))
+	}
+
+	promcfg, jobs, err := config.InsertMetricRelabelConfigToPromConfig(mrcs, hcs.Jobs, pc)
+	require.NoError(t, err)
+	require.Len(t, promcfg.ScrapeConfigs[0].MetricRelabelConfigs, 3)
+	require.NoError(t, config.WritePromConfig(promcfg, pc))
+	hcs.Jobs = jobs
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, config.HashModSuppressor{Buckets: 4}, mrcs, bc))
+
+	bscfg, err := config.ReadBombSquadConfig(bc)
+	require.NoError(t, err)
+	require.Equal(t, config.StrategyHashMod, bscfg.Silences["foo.bar"].Strategy)
+	require.Len(t, bscfg.Silences["foo.bar"].Rules, 3)
+
+	require.NoError(t, config.RemoveSilence("foo.bar", pc, bc))
+	promcfg, err = config.ReadPromConfig(pc)
+	require.NoError(t, err)
+	require.Empty(t, promcfg.ScrapeConfigs[0].MetricRelabelConfigs)
+}
+
+func TestTopKSuppressorKeepsMostCommonValues(t *testing.T) {
+	s := config.TopKSuppressor{K: 2, Other: "other"}
+	hcs := config.HighCardSeries{
+		MetricName:        "foo",
+		HighCardLabelName: "route",
+		ValueCounts:       map[string]int{"/users": 50, "/orders": 40, "/x/1f2e": 1, "/x/9a8b": 1, "other": 500},
+	}
+	require.Equal(t, []string{"/orders", "/users"}, s.Keep(hcs))
+
+	mrcs, err := s.RelabelConfigs(hcs)
+	require.NoError(t, err)
+	require.Len(t, mrcs, 3)
+
+	// Kept values are copied to the temporary label...
+	require.Equal(t, "__tmp_bs_keep_foo_route", mrcs[0].TargetLabel)
+	require.True(t, mrcs[0].Regex.MatchString("foo;/users"))
+	require.False(t, mrcs[0].Regex.MatchString("foo;/x/1f2e"))
+
+	// ...so that only the rest get rewritten
+	require.Equal(t, "other", mrcs[1].Replacement)
+	require.True(t, mrcs[1].Regex.MatchString("foo;/x/1f2e;"))
+	require.False(t, mrcs[1].Regex.MatchString("foo;/users;/users"))
+	require.False(t, mrcs[1].Regex.MatchString("foo;;"))
+
+	_, err = s.RelabelConfigs(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "route"})
+	require.Error(t, err)
+}
+
+func TestTopKSuppressorHandlesRecordedMetricNames(t *testing.T) {
+	s := config.TopKSuppressor{K: 2, Other: "other"}
+	mrcs, err := s.RelabelConfigs(config.HighCardSeries{
+		MetricName:        "job:requests:rate5m",
+		HighCardLabelName: "bar",
+		ValueCounts:       map[string]int{"a": 3, "b": 1},
+	})
+	require.NoError(t, err)
+	for i := range mrcs {
+		require.NoError(t, prom.ReUnmarshal(&mrcs[i]))
+	}
+	require.Regexp(t, "^__tmp_bs_keep_job_requests_rate5m_[0-9a-f]{8}_bar$", mrcs[0].TargetLabel)
+	require.True(t, model.LabelName(mrcs[0].TargetLabel).IsValid())
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Defaults for the detection thresholds of a Target
+const (
+	DefaultHighCardN                 = 5
+	DefaultHighCardThreshold         = 100
+	DefaultLabelNameGrowthThreshold  = 20
+	DefaultMetricNameGrowthThreshold = 50
+)
+
+// Target is one of several Prometheus servers watched by the same Bomb Squad,
+// ex. the shards of a sharded Prometheus, each with its own config
+type Target struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+	// ConfigMap or Secret, depending on -prom-config-kind, holds the target's
+	// Prometheus config in Kubernetes, and ConfigFile outside of it
+	ConfigMap  string `yaml:"config_map,omitempty"`
+	Secret     string `yaml:"secret,omitempty"`
+	ConfigFile string `yaml:"config_file,omitempty"`
+
+	HighCardN                 int     `yaml:"high_card_n,omitempty"`
+	HighCardThreshold         float64 `yaml:"high_card_threshold,omitempty"`
+	LabelNameGrowthThreshold  int     `yaml:"label_name_growth_threshold,omitempty"`
+	MetricNameGrowthThreshold int     `yaml:"metric_name_growth_threshold,omitempty"`
+}
+
+// WithDefaults returns the target with unset thresholds set to their defaults
+func (t Target) WithDefaults() Target {
+	if t.HighCardN == 0 {
+		t.HighCardN = DefaultHighCardN
+	}
+	if t.HighCardThreshold == 0 {
+		t.HighCardThreshold = DefaultHighCardThreshold
+	}
+	if t.LabelNameGrowthThreshold == 0 {
+		t.LabelNameGrowthThreshold = DefaultLabelNameGrowthThreshold
+	}
+	if t.MetricNameGrowthThreshold == 0 {
+		t.MetricNameGrowthThreshold = DefaultMetricNameGrowthThreshold
+	}
+	return t
+}
+
+// targetsFile is how targets are listed
+type targetsFile struct {
+	Targets []Target `yaml:"targets"`
+}
+
+// LoadTargets reads a list of targets, each with a unique name and a URL,
+// and fills in their default thresholds
+func LoadTargets(b []byte) ([]Target, error) {
+	f := targetsFile{}
+	err := yaml.UnmarshalStrict(b, &f)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal targets: %s", err)
+	}
+	if len(f.Targets) == 0 {
+		return nil, fmt.Errorf("No targets listed")
+	}
+
+	seen := map[string]bool{}
+	for i, t := range f.Targets {
+		if t.Name == "" {
+			return nil, fmt.Errorf("Target %d has no name", i)
+		}
+		if seen[t.Name] {
+			return nil, fmt.Errorf("Target %s is listed twice", t.Name)
+		}
+		seen[t.Name] = true
+		if t.URL == "" {
+			return nil, fmt.Errorf("Target %s has no url", t.Name)
+		}
+		f.Targets[i] = t.WithDefaults()
+	}
+	return f.Targets, nil
+}
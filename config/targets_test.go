@@ -0,0 +1,39 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTargets(t *testing.T) {
+	targets, err := config.LoadTargets([]byte(`targets:
+- name: shard-0
+  url: http://prometheus-0:9090
+  config_map: prometheus-0
+- name: shard-1
+  url: http://prometheus-1:9090
+  config_map: prometheus-1
+  high_card_threshold: 500
+`))
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	require.Equal(t, "prometheus-0", targets[0].ConfigMap)
+	require.Equal(t, float64(config.DefaultHighCardThreshold), targets[0].HighCardThreshold)
+	require.Equal(t, float64(500), targets[1].HighCardThreshold)
+	require.Equal(t, config.DefaultHighCardN, targets[1].HighCardN)
+}
+
+func TestLoadTargetsRefusesAmbiguousTargets(t *testing.T) {
+	for _, targets := range []string{
+		``,
+		`targets: [{url: "http://prometheus:9090"}]`,
+		`targets: [{name: a, url: "http://a:9090"}, {name: a, url: "http://b:9090"}]`,
+		`targets: [{name: a}]`,
+		`targets: [{name: a, url: "http://a:9090", threshold: 5}]`,
+	} {
+		_, err := config.LoadTargets([]byte(targets))
+		require.Error(t, err, targets)
+	}
+}
@@ -0,0 +1,108 @@
+package file
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileConfigurator reads and writes a config file on local disk. It
+// implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+type FileConfigurator struct {
+	Path string
+}
+
+// NewFileConfigurator returns a FileConfigurator
+func NewFileConfigurator(path string) *FileConfigurator {
+	return &FileConfigurator{
+		Path: path,
+	}
+}
+
+// GetLocation implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *FileConfigurator) GetLocation() string {
+	return c.Path
+}
+
+// Read implements github.com/Fresh-Tracks/bomb-squad/config.Configurator. A
+// missing file reads as empty, the same as a missing ConfigMap key.
+func (c *FileConfigurator) Read() ([]byte, error) {
+	unlock, err := lock(c.lockPath(), false)
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to lock %s for reading: %s", c.Path, err)
+	}
+	defer unlock()
+
+	b, err := ioutil.ReadFile(c.Path)
+	if os.IsNotExist(err) {
+		return []byte{}, nil
+	}
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to read %s: %s", c.Path, err)
+	}
+	return b, nil
+}
+
+// Write implements github.com/Fresh-Tracks/bomb-squad/config.Configurator.
+// The data goes to a temporary file alongside the config first, which is then
+// renamed over it, so nobody ever sees half a config.
+func (c *FileConfigurator) Write(data []byte) error {
+	unlock, err := lock(c.lockPath(), true)
+	if err != nil {
+		return fmt.Errorf("Failed to lock %s for writing: %s", c.Path, err)
+	}
+	defer unlock()
+
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(c.Path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+
+	dir, base := filepath.Split(c.Path)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s: %s", c.Path, err)
+	}
+	// Cleans up after any failure below. Once renamed, there's nothing left
+	// to remove.
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to write temporary file for %s: %s", c.Path, err)
+	}
+
+	err = os.Chmod(tmp.Name(), mode)
+	if err != nil {
+		return fmt.Errorf("Failed to set permissions on temporary file for %s: %s", c.Path, err)
+	}
+
+	err = os.Rename(tmp.Name(), c.Path)
+	if err != nil {
+		return fmt.Errorf("Failed to replace %s: %s", c.Path, err)
+	}
+
+	// Make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
+
+// lockPath is the file locked while reading or writing the config. It can't
+// be the config itself, since every write replaces that with a new file.
+func (c *FileConfigurator) lockPath() string {
+	dir, base := filepath.Split(c.Path)
+	return filepath.Join(dir, "."+base+".lock")
+}
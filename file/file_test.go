@@ -0,0 +1,76 @@
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func tempConfigurator(t *testing.T) (*FileConfigurator, func()) {
+	dir, err := ioutil.TempDir("", "bomb-squad")
+	require.NoError(t, err)
+	return NewFileConfigurator(filepath.Join(dir, "prometheus.yml")), func() { os.RemoveAll(dir) }
+}
+
+func TestCanReadMissingFile(t *testing.T) {
+	c, done := tempConfigurator(t)
+	defer done()
+
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Empty(t, b)
+}
+
+func TestCanWriteFile(t *testing.T) {
+	c, done := tempConfigurator(t)
+	defer done()
+
+	require.NoError(t, ioutil.WriteFile(c.Path, []byte("FooBar"), 0600))
+	require.NoError(t, c.Write([]byte("BazBat")))
+
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, "BazBat", string(b))
+
+	fi, err := os.Stat(c.Path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	// Only the config and its lock file are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(c.Path))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestConcurrentWritesAreWhole(t *testing.T) {
+	c, done := tempConfigurator(t)
+	defer done()
+
+	a, b := []byte("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"), []byte("bbb")
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			require.NoError(t, c.Write(a))
+		}()
+		go func() {
+			defer wg.Done()
+			got, err := c.Read()
+			require.NoError(t, err)
+			if len(got) > 0 && string(got) != string(a) {
+				t.Errorf("read partial config %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, c.Write(b))
+	got, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, b, got)
+}
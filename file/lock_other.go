@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package file
+
+// lock is a no-op where flock(2) isn't available. Writes are still atomic,
+// but concurrent writers can lose each other's changes.
+func lock(path string, exclusive bool) (func(), error) {
+	return func() {}, nil
+}
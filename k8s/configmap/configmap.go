@@ -2,7 +2,9 @@ package configmap
 
 import (
 	"fmt"
+	"sync"
 
+	k8sAPICoreV1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/util/retry"
@@ -15,8 +17,24 @@ type ConfigMapWrapper struct {
 	Client  kcorev1.ConfigMapInterface
 	Name    string
 	DataKey string
+	// Informer, if set, serves reads from a local copy of the ConfigMap
+	// instead of the API server. Writes always go to the API server.
+	Informer *Informer
+
+	// pendingWrites and writtenVersions tell Bomb Squad's own changes apart
+	// from everyone else's: the data of the writes under way, since the watch
+	// may report them before they return, and the resourceVersions of the
+	// last writes done
+	pendingWrites   map[string]int
+	writtenVersions []string
+	writesLock      sync.Mutex
 }
 
+// writtenVersionsKept is how many resourceVersions of its own writes a
+// ConfigMapWrapper remembers, enough for a write and its rollback to be long
+// reported by the watch before they're forgotten
+const writtenVersionsKept = 16
+
 // NewConfigMapWrapper returns a ConfigMapWrapper
 func NewConfigMapWrapper(client kcorev1.ConfigMapInterface, namespace string, configMapName string, dataKey string) *ConfigMapWrapper {
 	return &ConfigMapWrapper{
@@ -34,6 +52,12 @@ func (c *ConfigMapWrapper) GetLocation() string {
 // Read implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
 func (c *ConfigMapWrapper) Read() ([]byte, error) {
 	dataKey := c.GetLocation()
+	if c.Informer != nil {
+		if cm, ok := c.Informer.get(); ok {
+			return []byte(cm.Data[dataKey]), nil
+		}
+	}
+
 	cm, err := c.Client.Get(c.Name, v1.GetOptions{})
 	if err != nil {
 		return []byte{}, fmt.Errorf("Failed to get ConfigMap in preparation for Configurator.Read(): %s", err)
@@ -48,6 +72,9 @@ func (c *ConfigMapWrapper) Read() ([]byte, error) {
 func (c *ConfigMapWrapper) Write(data []byte) error {
 
 	dataKey := c.GetLocation()
+	c.startWrite(string(data))
+	defer c.endWrite(string(data))
+
 	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
 		// Retrieve the latest version of ConfigMap before attempting update
 		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
@@ -57,12 +84,19 @@ func (c *ConfigMapWrapper) Write(data []byte) error {
 			return fmt.Errorf("Failed to get latest version of ConfigMap: %v", err)
 		}
 
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
 		cm.Data[dataKey] = string(data)
 
-		_, updateErr := c.Client.Update(cm)
+		updated, updateErr := c.Client.Update(cm)
 		if updateErr != nil {
 			return fmt.Errorf("ConfigMap update failed: %v", updateErr)
 		}
+		c.wrote(updated.ResourceVersion)
+		if c.Informer != nil {
+			c.Informer.wrote(updated)
+		}
 
 		return updateErr
 	})
@@ -73,3 +107,68 @@ func (c *ConfigMapWrapper) Write(data []byte) error {
 
 	return nil
 }
+
+// OnExternalChange calls f whenever the Informer sees the data under DataKey
+// change to something Bomb Squad didn't write, ex. after a hand edit or a
+// `helm upgrade`
+func (c *ConfigMapWrapper) OnExternalChange(f func()) {
+	if c.Informer == nil {
+		return
+	}
+	dataKey := c.GetLocation()
+	c.Informer.onChange(func(old, new *k8sAPICoreV1.ConfigMap) {
+		if old.Data[dataKey] == new.Data[dataKey] {
+			return
+		}
+		if !c.isOwnWrite(new.ResourceVersion, new.Data[dataKey]) {
+			f()
+		}
+	})
+}
+
+func (c *ConfigMapWrapper) startWrite(data string) {
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+	if c.pendingWrites == nil {
+		c.pendingWrites = map[string]int{}
+	}
+	c.pendingWrites[data]++
+}
+
+func (c *ConfigMapWrapper) endWrite(data string) {
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+	c.pendingWrites[data]--
+	if c.pendingWrites[data] <= 0 {
+		delete(c.pendingWrites, data)
+	}
+}
+
+// wrote records the resourceVersion of a write done
+func (c *ConfigMapWrapper) wrote(resourceVersion string) {
+	if resourceVersion == "" {
+		return
+	}
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+	c.writtenVersions = append(c.writtenVersions, resourceVersion)
+	if len(c.writtenVersions) > writtenVersionsKept {
+		c.writtenVersions = c.writtenVersions[len(c.writtenVersions)-writtenVersionsKept:]
+	}
+}
+
+// isOwnWrite reports whether the ConfigMap the watch saw is one Bomb Squad
+// wrote
+func (c *ConfigMapWrapper) isOwnWrite(resourceVersion, data string) bool {
+	c.writesLock.Lock()
+	defer c.writesLock.Unlock()
+	if c.pendingWrites[data] > 0 {
+		return true
+	}
+	for _, v := range c.writtenVersions {
+		if v == resourceVersion {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package configmap
+
+import (
+	"time"
+
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// resyncPeriod is how often the informer hands its copy of the ConfigMap
+	// to its handlers again, in case an event was missed
+	resyncPeriod = 10 * time.Minute
+	// mutationTTL is how long a ConfigMap Bomb Squad wrote is read back in
+	// place of an older copy from the watch
+	mutationTTL = 10 * time.Minute
+)
+
+// Informer keeps a local copy of a ConfigMap up to date by watching it, so
+// reading it doesn't take a round trip to the API server. It can be shared by
+// the ConfigMapWrappers of several data keys of the same ConfigMap.
+type Informer struct {
+	namespace string
+	name      string
+	informer  cache.SharedInformer
+	// mutations layers the ConfigMaps Bomb Squad wrote over the watched
+	// copies, so that reads right after a write see it even if the watch
+	// hasn't caught up yet
+	mutations cache.MutationCache
+}
+
+// NewInformer returns an Informer for the named ConfigMap. It's empty until
+// it's Run.
+func NewInformer(client kcorev1.ConfigMapInterface, namespace, name string) *Informer {
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return client.List(options)
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return client.Watch(options)
+		},
+	}
+
+	informer := cache.NewSharedInformer(lw, &k8sAPICoreV1.ConfigMap{}, resyncPeriod)
+	return &Informer{
+		namespace: namespace,
+		name:      name,
+		informer:  informer,
+		mutations: cache.NewIntegerResourceVersionMutationCache(informer.GetStore(), nil, mutationTTL, false),
+	}
+}
+
+// Run starts watching the ConfigMap until stopCh is closed, and reports
+// whether the local copy was filled in within the timeout. Until it is, reads
+// go to the API server.
+func (i *Informer) Run(stopCh <-chan struct{}, timeout time.Duration) bool {
+	go i.informer.Run(stopCh)
+
+	waitCh := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(waitCh) })
+	defer timer.Stop()
+	return cache.WaitForCacheSync(waitCh, i.informer.HasSynced)
+}
+
+// get returns the local copy of the ConfigMap, if there is one yet
+func (i *Informer) get() (*k8sAPICoreV1.ConfigMap, bool) {
+	if !i.informer.HasSynced() {
+		return nil, false
+	}
+	obj, exists, err := i.mutations.GetByKey(i.namespace + "/" + i.name)
+	if err != nil || !exists {
+		return nil, false
+	}
+	cm, ok := obj.(*k8sAPICoreV1.ConfigMap)
+	return cm, ok
+}
+
+// wrote records a ConfigMap as written by Bomb Squad
+func (i *Informer) wrote(cm *k8sAPICoreV1.ConfigMap) {
+	i.mutations.Mutation(cm)
+}
+
+// onChange calls f with the old and new copies of the ConfigMap whenever the
+// watch sees it change
+func (i *Informer) onChange(f func(old, new *k8sAPICoreV1.ConfigMap)) {
+	i.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			old, ok := oldObj.(*k8sAPICoreV1.ConfigMap)
+			if !ok {
+				return
+			}
+			cm, ok := newObj.(*k8sAPICoreV1.ConfigMap)
+			if !ok {
+				return
+			}
+			f(old, cm)
+		},
+	})
+}
@@ -0,0 +1,139 @@
+package configmap
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	kCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+// versionedConfigMapClient returns a fake client that, like the API server,
+// gives every ConfigMap it stores a new resourceVersion
+func versionedConfigMapClient() kCoreV1.ConfigMapInterface {
+	clientset := fake.NewSimpleClientset()
+	var (
+		lock    sync.Mutex
+		version int
+	)
+	// Reactors see a copy of the action, so stamp the version on that copy
+	// and hand it to the default object tracker ourselves.
+	defaults := clientset.ReactionChain
+	clientset.PrependReactor("*", "configmaps", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if verb := action.GetVerb(); verb != "create" && verb != "update" {
+			return false, nil, nil
+		}
+		obj := action.(interface {
+			GetObject() runtime.Object
+		}).GetObject()
+		lock.Lock()
+		version++
+		obj.(metaV1.Object).SetResourceVersion(strconv.Itoa(version))
+		lock.Unlock()
+		for _, reactor := range defaults {
+			if !reactor.Handles(action) {
+				continue
+			}
+			if handled, ret, err := reactor.React(action); handled {
+				return handled, ret, err
+			}
+		}
+		return false, nil, nil
+	})
+	return clientset.CoreV1().ConfigMaps("testNamespace")
+}
+
+// eventually waits for cond to hold
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadsFromInformer(t *testing.T) {
+	client := versionedConfigMapClient()
+	_, _ = client.Create(newConfigMap())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informer := NewInformer(client, "testNamespace", "testConfigMap")
+	require.True(t, informer.Run(stopCh, 5*time.Second))
+
+	cmw := NewConfigMapWrapper(client, "testNamespace", "testConfigMap", "testDataKey")
+	cmw.Informer = informer
+	changes := make(chan struct{}, 10)
+	cmw.OnExternalChange(func() { changes <- struct{}{} })
+
+	b, err := cmw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "FooBar", string(b))
+
+	// Someone else edits the ConfigMap
+	cm, err := client.Get("testConfigMap", metaV1.GetOptions{})
+	require.NoError(t, err)
+	cm.Data["testDataKey"] = "Edited"
+	_, err = client.Update(cm)
+	require.NoError(t, err)
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("external change not reported")
+	}
+	b, err = cmw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "Edited", string(b))
+
+	// Bomb Squad's own writes aren't reported as external changes
+	require.NoError(t, cmw.Write([]byte("BazBat")))
+	eventually(t, func() bool {
+		b, err := cmw.Read()
+		return err == nil && string(b) == "BazBat"
+	})
+	require.Empty(t, changes)
+}
+
+func TestRolledBackWritesAreNotExternalChanges(t *testing.T) {
+	client := versionedConfigMapClient()
+	_, _ = client.Create(newConfigMap())
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	informer := NewInformer(client, "testNamespace", "testConfigMap")
+	require.True(t, informer.Run(stopCh, 5*time.Second))
+
+	cmw := NewConfigMapWrapper(client, "testNamespace", "testConfigMap", "testDataKey")
+	cmw.Informer = informer
+	changes := make(chan struct{}, 10)
+	cmw.OnExternalChange(func() { changes <- struct{}{} })
+
+	// A write, then its rollback
+	require.NoError(t, cmw.Write([]byte("BazBat")))
+	require.NoError(t, cmw.Write([]byte("FooBar")))
+
+	// Someone else's edit comes after both, so once it's reported they were
+	// seen too
+	cm, err := client.Get("testConfigMap", metaV1.GetOptions{})
+	require.NoError(t, err)
+	cm.Data["testDataKey"] = "Edited"
+	_, err = client.Update(cm)
+	require.NoError(t, err)
+
+	select {
+	case <-changes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("external change not reported")
+	}
+	time.Sleep(50 * time.Millisecond)
+	require.Empty(t, changes)
+}
@@ -0,0 +1,121 @@
+package configmap
+
+import (
+	"fmt"
+	"log"
+
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// EnsureConfigMap creates the named ConfigMap if it doesn't exist yet. If
+// owner is set, the ConfigMap is garbage collected along with it.
+func EnsureConfigMap(client kcorev1.ConfigMapInterface, namespace string, name string, owner *v1.OwnerReference) error {
+	_, err := client.Get(name, v1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to get ConfigMap %s: %s", name, err)
+	}
+
+	cm := &k8sAPICoreV1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "bomb-squad"},
+		},
+		Data: map[string]string{},
+	}
+	if owner != nil {
+		cm.OwnerReferences = []v1.OwnerReference{*owner}
+	}
+
+	_, err = client.Create(cm)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to create ConfigMap %s: %s", name, err)
+	}
+	log.Printf("Created ConfigMap %s for Bomb Squad state\n", name)
+	return nil
+}
+
+// OwnerReference returns a reference to the named ConfigMap, for objects
+// that should go when it does
+func OwnerReference(client kcorev1.ConfigMapInterface, name string) (*v1.OwnerReference, error) {
+	cm, err := client.Get(name, v1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get ConfigMap %s: %s", name, err)
+	}
+
+	return &v1.OwnerReference{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Name:       cm.Name,
+		UID:        cm.UID,
+	}, nil
+}
+
+// MoveDataKey moves a data key from one ConfigMap to another, where it may
+// go by another key. The key is only deleted from the source once the
+// destination holds it, so an interrupted move is finished by running it
+// again. A destination that already holds the key wins over the source, and a
+// missing source has nothing to move.
+func MoveDataKey(client kcorev1.ConfigMapInterface, from string, to string, fromKey string, toKey string) error {
+	src, err := client.Get(from, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get ConfigMap %s: %s", from, err)
+	}
+	data, ok := src.Data[fromKey]
+	if !ok {
+		return nil
+	}
+
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dst, err := client.Get(to, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if dst.Data[toKey] != "" {
+			return nil
+		}
+		if dst.Data == nil {
+			dst.Data = map[string]string{}
+		}
+		dst.Data[toKey] = data
+
+		_, err = client.Update(dst)
+		return err
+	})
+	if retryErr != nil {
+		return fmt.Errorf("Failed to copy %s to ConfigMap %s: %s", fromKey, to, retryErr)
+	}
+
+	retryErr = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		src, err := client.Get(from, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, ok := src.Data[fromKey]; !ok {
+			return nil
+		}
+		delete(src.Data, fromKey)
+
+		_, err = client.Update(src)
+		return err
+	})
+	if retryErr != nil {
+		return fmt.Errorf("Failed to remove %s from ConfigMap %s: %s", fromKey, from, retryErr)
+	}
+
+	log.Printf("Moved %s from ConfigMap %s to %s in %s\n", fromKey, from, toKey, to)
+	return nil
+}
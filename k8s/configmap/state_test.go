@@ -0,0 +1,63 @@
+package configmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnsureConfigMapCreatesOwnedConfigMap(t *testing.T) {
+	client := fakeConfigMapClient()
+	_, _ = client.Create(newConfigMap())
+
+	owner, err := OwnerReference(client, "testConfigMap")
+	require.NoError(t, err)
+	require.NoError(t, EnsureConfigMap(client, "testNamespace", "bomb-squad-state", owner))
+
+	cm, err := client.Get("bomb-squad-state", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, []metaV1.OwnerReference{*owner}, cm.OwnerReferences)
+
+	// An existing ConfigMap is left alone
+	cmw := NewConfigMapWrapper(client, "testNamespace", "bomb-squad-state", "bomb-squad")
+	require.NoError(t, cmw.Write([]byte("state")))
+	require.NoError(t, EnsureConfigMap(client, "testNamespace", "bomb-squad-state", owner))
+	b, err := cmw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "state", string(b))
+}
+
+func TestMoveDataKey(t *testing.T) {
+	client := fakeConfigMapClient()
+	_, _ = client.Create(newConfigMap())
+	require.NoError(t, EnsureConfigMap(client, "testNamespace", "bomb-squad-state", nil))
+
+	require.NoError(t, MoveDataKey(client, "testConfigMap", "bomb-squad-state", "testDataKey", "testDataKey-a"))
+
+	src, err := client.Get("testConfigMap", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, src.Data, "testDataKey")
+	dst, err := client.Get("bomb-squad-state", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "FooBar", dst.Data["testDataKey-a"])
+
+	// Nothing left to move
+	require.NoError(t, MoveDataKey(client, "testConfigMap", "bomb-squad-state", "testDataKey", "testDataKey-a"))
+}
+
+func TestMoveDataKeyKeepsNewerState(t *testing.T) {
+	client := fakeConfigMapClient()
+	_, _ = client.Create(newConfigMap())
+	require.NoError(t, EnsureConfigMap(client, "testNamespace", "bomb-squad-state", nil))
+	require.NoError(t, NewConfigMapWrapper(client, "testNamespace", "bomb-squad-state", "testDataKey").Write([]byte("Newer")))
+
+	require.NoError(t, MoveDataKey(client, "testConfigMap", "bomb-squad-state", "testDataKey", "testDataKey"))
+
+	dst, err := client.Get("bomb-squad-state", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "Newer", dst.Data["testDataKey"])
+	src, err := client.Get("testConfigMap", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, src.Data, "testDataKey")
+}
@@ -0,0 +1,73 @@
+package events
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// Component is the source Bomb Squad's events are recorded under
+const Component = "bomb-squad"
+
+// ObjectEventSink records Kubernetes events against the ConfigMap or Secret
+// holding the Prometheus config. It implements github.com/Fresh-Tracks/bomb-squad/prom.EventSink
+type ObjectEventSink struct {
+	// EventInterface is a client, not an Event itself
+	Client    kcorev1.EventInterface
+	Namespace string
+	Kind      string
+	Name      string
+}
+
+// NewConfigMapEventSink returns an ObjectEventSink for a ConfigMap
+func NewConfigMapEventSink(client kcorev1.EventInterface, namespace string, configMapName string) *ObjectEventSink {
+	return &ObjectEventSink{
+		Client:    client,
+		Namespace: namespace,
+		Kind:      "ConfigMap",
+		Name:      configMapName,
+	}
+}
+
+// NewSecretEventSink returns an ObjectEventSink for a Secret
+func NewSecretEventSink(client kcorev1.EventInterface, namespace string, secretName string) *ObjectEventSink {
+	return &ObjectEventSink{
+		Client:    client,
+		Namespace: namespace,
+		Kind:      "Secret",
+		Name:      secretName,
+	}
+}
+
+// Warning implements github.com/Fresh-Tracks/bomb-squad/prom.EventSink.
+// Failing to record an event is logged rather than returned, since events are
+// only ever a courtesy.
+func (s *ObjectEventSink) Warning(reason, message string) {
+	now := v1.NewTime(time.Now())
+	_, err := s.Client.Create(&k8sAPICoreV1.Event{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.%x", s.Name, now.UnixNano()),
+			Namespace: s.Namespace,
+		},
+		InvolvedObject: k8sAPICoreV1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       s.Kind,
+			Namespace:  s.Namespace,
+			Name:       s.Name,
+		},
+		Reason:         reason,
+		Message:        message,
+		Source:         k8sAPICoreV1.EventSource{Component: Component},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           k8sAPICoreV1.EventTypeWarning,
+	})
+	if err != nil {
+		log.Printf("Failed to record %s event: %s\n", reason, err)
+	}
+}
@@ -0,0 +1,29 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCanRecordWarning(t *testing.T) {
+	client := fake.NewSimpleClientset().CoreV1().Events("testNamespace")
+	s := NewConfigMapEventSink(client, "testNamespace", "testConfigMap")
+
+	s.Warning("PrometheusConfigRolledBack", "rolled back")
+
+	events, err := client.List(metaV1.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, events.Items, 1)
+
+	e := events.Items[0]
+	require.Equal(t, "PrometheusConfigRolledBack", e.Reason)
+	require.Equal(t, "rolled back", e.Message)
+	require.Equal(t, k8sAPICoreV1.EventTypeWarning, e.Type)
+	require.Equal(t, "ConfigMap", e.InvolvedObject.Kind)
+	require.Equal(t, "testConfigMap", e.InvolvedObject.Name)
+	require.Equal(t, Component, e.Source.Component)
+}
@@ -0,0 +1,174 @@
+package lease
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	coordinationv1beta1 "k8s.io/api/coordination/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcoordinationv1beta1 "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
+)
+
+var (
+	LeaderGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "leader",
+			Help:      "Whether this Bomb Squad holds the leader Lease, and so is the one changing configs",
+		},
+	)
+)
+
+// Elector takes and keeps a Kubernetes Lease, so that of several Bomb Squad
+// replicas only one changes configs at a time. A Lease its holder hasn't
+// renewed for LeaseDuration is up for grabs. As in client-go, that's timed
+// from when this replica last saw the Lease change, so clocks needn't agree.
+type Elector struct {
+	Client        kcoordinationv1beta1.LeaseInterface
+	Namespace     string
+	Name          string
+	Identity      string
+	LeaseDuration time.Duration
+	// RetryPeriod is how often the Lease is renewed, or tried for
+	RetryPeriod time.Duration
+
+	leading int32
+	// observed is the holder and renew time of the Lease when it last
+	// changed, and observedAt when that was seen
+	observed   string
+	observedAt time.Time
+	now        func() time.Time
+}
+
+// NewElector returns an Elector for the named Lease, renewing it every third
+// of the lease duration
+func NewElector(client kcoordinationv1beta1.LeaseInterface, namespace, name, identity string, leaseDuration time.Duration) *Elector {
+	return &Elector{
+		Client:        client,
+		Namespace:     namespace,
+		Name:          name,
+		Identity:      identity,
+		LeaseDuration: leaseDuration,
+		RetryPeriod:   leaseDuration / 3,
+		now:           time.Now,
+	}
+}
+
+// IsLeader reports whether this replica held the Lease when last it checked
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leading) == 1
+}
+
+// Run renews or tries for the Lease every RetryPeriod until stopCh is closed
+func (e *Elector) Run(stopCh <-chan struct{}) {
+	ticker := time.NewTicker(e.RetryPeriod)
+	defer ticker.Stop()
+	for {
+		leading, err := e.TryAcquireOrRenew()
+		if err != nil {
+			log.Printf("Couldn't renew Lease %s: %s\n", e.Name, err)
+		}
+		e.setLeading(leading)
+
+		select {
+		case <-stopCh:
+			e.setLeading(false)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (e *Elector) setLeading(leading bool) {
+	var v int32
+	if leading {
+		v = 1
+	}
+	if atomic.SwapInt32(&e.leading, v) != v {
+		if leading {
+			log.Printf("%s is now the leader, and changes configs\n", e.Identity)
+		} else {
+			log.Printf("%s is no longer the leader, and only observes\n", e.Identity)
+		}
+	}
+	LeaderGauge.Set(float64(v))
+}
+
+// TryAcquireOrRenew renews the Lease if this replica holds it, or takes it
+// over if it's free or expired, and reports whether this replica holds it now
+func (e *Elector) TryAcquireOrRenew() (bool, error) {
+	now := v1.NewMicroTime(e.now())
+	durationSeconds := int32(e.LeaseDuration / time.Second)
+
+	l, err := e.Client.Get(e.Name, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		l = &coordinationv1beta1.Lease{
+			ObjectMeta: v1.ObjectMeta{Namespace: e.Namespace, Name: e.Name},
+			Spec: coordinationv1beta1.LeaseSpec{
+				HolderIdentity:       &e.Identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		_, err = e.Client.Create(l)
+		if err != nil {
+			return false, fmt.Errorf("Couldn't create Lease: %s", err)
+		}
+		e.observe(l)
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Couldn't get Lease: %s", err)
+	}
+
+	e.observe(l)
+	holder := ""
+	if l.Spec.HolderIdentity != nil {
+		holder = *l.Spec.HolderIdentity
+	}
+	if holder != e.Identity && holder != "" && e.now().Before(e.observedAt.Add(e.LeaseDuration)) {
+		return false, nil
+	}
+
+	if holder != e.Identity {
+		transitions := int32(0)
+		if l.Spec.LeaseTransitions != nil {
+			transitions = *l.Spec.LeaseTransitions
+		}
+		if holder != "" {
+			transitions++
+		}
+		l.Spec.HolderIdentity = &e.Identity
+		l.Spec.AcquireTime = &now
+		l.Spec.LeaseTransitions = &transitions
+	}
+	l.Spec.LeaseDurationSeconds = &durationSeconds
+	l.Spec.RenewTime = &now
+
+	// A conflict means another replica got there first
+	l, err = e.Client.Update(l)
+	if err != nil {
+		return false, fmt.Errorf("Couldn't update Lease: %s", err)
+	}
+	e.observe(l)
+	return true, nil
+}
+
+// observe notes when the Lease last changed hands or was renewed
+func (e *Elector) observe(l *coordinationv1beta1.Lease) {
+	record := ""
+	if l.Spec.HolderIdentity != nil {
+		record = *l.Spec.HolderIdentity
+	}
+	if l.Spec.RenewTime != nil {
+		record += "@" + l.Spec.RenewTime.String()
+	}
+	if record != e.observed {
+		e.observed, e.observedAt = record, e.now()
+	}
+}
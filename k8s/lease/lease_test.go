@@ -0,0 +1,58 @@
+package lease
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestOnlyOneReplicaLeads(t *testing.T) {
+	client := fake.NewSimpleClientset().CoordinationV1beta1().Leases("monitoring")
+	clock := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := func() time.Time { return clock }
+
+	a := NewElector(client, "monitoring", "bomb-squad", "prometheus-0", 15*time.Second)
+	b := NewElector(client, "monitoring", "bomb-squad", "prometheus-1", 15*time.Second)
+	a.now, b.now = now, now
+
+	leading, err := a.TryAcquireOrRenew()
+	require.NoError(t, err)
+	require.True(t, leading)
+	leading, err = b.TryAcquireOrRenew()
+	require.NoError(t, err)
+	require.False(t, leading)
+
+	// a keeps renewing, so b never gets a look in
+	for i := 0; i < 5; i++ {
+		clock = clock.Add(10 * time.Second)
+		leading, err = a.TryAcquireOrRenew()
+		require.NoError(t, err)
+		require.True(t, leading)
+		leading, err = b.TryAcquireOrRenew()
+		require.NoError(t, err)
+		require.False(t, leading)
+	}
+
+	// a goes away, and b takes over once the Lease expires
+	clock = clock.Add(10 * time.Second)
+	leading, err = b.TryAcquireOrRenew()
+	require.NoError(t, err)
+	require.False(t, leading)
+	clock = clock.Add(10 * time.Second)
+	leading, err = b.TryAcquireOrRenew()
+	require.NoError(t, err)
+	require.True(t, leading)
+
+	l, err := client.Get("bomb-squad", v1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "prometheus-1", *l.Spec.HolderIdentity)
+	require.Equal(t, int32(1), *l.Spec.LeaseTransitions)
+
+	// a comes back to find it lost the Lease
+	leading, err = a.TryAcquireOrRenew()
+	require.NoError(t, err)
+	require.False(t, leading)
+}
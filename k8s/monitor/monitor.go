@@ -0,0 +1,514 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/util/retry"
+)
+
+// The kinds of Prometheus Operator monitors Bomb Squad puts silences in
+const (
+	ServiceMonitor = "ServiceMonitor"
+	PodMonitor     = "PodMonitor"
+)
+
+// Kinds lists the monitor kinds in the order they're presented in
+var Kinds = []string{ServiceMonitor, PodMonitor}
+
+const apiGroupVersion = "monitoring.coreos.com/v1"
+
+var (
+	resources = map[string]string{
+		ServiceMonitor: "servicemonitors",
+		PodMonitor:     "podmonitors",
+	}
+	// endpointsFields are where each kind of monitor keeps its endpoints,
+	// each of which Prometheus Operator turns into one scrape config
+	endpointsFields = map[string]string{
+		ServiceMonitor: "endpoints",
+		PodMonitor:     "podMetricsEndpoints",
+	}
+	scrapePoolPrefixes = map[string]string{
+		ServiceMonitor: "serviceMonitor",
+		PodMonitor:     "podMonitor",
+	}
+)
+
+// Client lists and updates Prometheus Operator monitors
+type Client interface {
+	List(kind string) ([]unstructured.Unstructured, error)
+	Update(obj *unstructured.Unstructured) error
+}
+
+// RESTClient implements Client with plain requests to the Kubernetes API,
+// since client-go doesn't know the monitor types
+type RESTClient struct {
+	Client rest.Interface
+	// Namespace limits the monitors to a single namespace. Empty means all
+	// namespaces.
+	Namespace string
+}
+
+// NewRESTClient returns a RESTClient
+func NewRESTClient(client rest.Interface, namespace string) *RESTClient {
+	return &RESTClient{
+		Client:    client,
+		Namespace: namespace,
+	}
+}
+
+// List implements Client
+func (c *RESTClient) List(kind string) ([]unstructured.Unstructured, error) {
+	path := []string{"/apis", apiGroupVersion}
+	if c.Namespace != "" {
+		path = append(path, "namespaces", c.Namespace)
+	}
+	path = append(path, resources[kind])
+
+	b, err := c.Client.Get().AbsPath(path...).DoRaw()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list %ss: %s", kind, err)
+	}
+
+	list := &unstructured.UnstructuredList{}
+	err = list.UnmarshalJSON(b)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't unmarshal %s list: %s", kind, err)
+	}
+	return list.Items, nil
+}
+
+// Update implements Client. Its error is the API's own, so that conflicts
+// can be retried.
+func (c *RESTClient) Update(obj *unstructured.Unstructured) error {
+	b, err := obj.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("Couldn't marshal %s %s/%s: %s", obj.GetKind(), obj.GetNamespace(), obj.GetName(), err)
+	}
+
+	_, err = c.Client.Put().
+		AbsPath("/apis", apiGroupVersion, "namespaces", obj.GetNamespace(), resources[obj.GetKind()], obj.GetName()).
+		Body(b).
+		DoRaw()
+	return err
+}
+
+// Endpoint is one endpoint of a monitor
+type Endpoint struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Index     int
+}
+
+// ScrapePool returns the job_name Prometheus Operator gives the scrape config
+// of the endpoint
+func (e Endpoint) ScrapePool() string {
+	return fmt.Sprintf("%s/%s/%s/%d", scrapePoolPrefixes[e.Kind], e.Namespace, e.Name, e.Index)
+}
+
+// legacyScrapePool returns the job_name older Prometheus Operators give the
+// scrape config of a ServiceMonitor endpoint
+func (e Endpoint) legacyScrapePool() string {
+	return fmt.Sprintf("%s/%s/%d", e.Namespace, e.Name, e.Index)
+}
+
+// MonitorConfigurator implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+// for Prometheus Operator, which overwrites any change made to the Prometheus
+// config it generates. It presents the metricRelabelings and sampleLimit of
+// every ServiceMonitor and PodMonitor endpoint as a Prometheus config with one
+// scrape config per endpoint, and writes changes to those back to the
+// monitors.
+type MonitorConfigurator struct {
+	Client Client
+	// ScrapePoolJobs, if set, returns the job label values of the targets in
+	// each scrape pool (see github.com/Fresh-Tracks/bomb-squad/prom.ScrapePoolJobs).
+	// An endpoint whose targets all carry the same job label is presented as
+	// that job, so that silences land on the monitors of the jobs they were
+	// found in. Other endpoints are presented as their scrape pool.
+	ScrapePoolJobs func() (map[string][]string, error)
+}
+
+// NewMonitorConfigurator returns a MonitorConfigurator
+func NewMonitorConfigurator(client Client, scrapePoolJobs func() (map[string][]string, error)) *MonitorConfigurator {
+	return &MonitorConfigurator{
+		Client:         client,
+		ScrapePoolJobs: scrapePoolJobs,
+	}
+}
+
+// GetLocation implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *MonitorConfigurator) GetLocation() string {
+	return "ServiceMonitors and PodMonitors"
+}
+
+// presentedConfig is the Prometheus config a MonitorConfigurator reads as
+type presentedConfig struct {
+	ScrapeConfigs []presentedScrapeConfig `yaml:"scrape_configs"`
+}
+
+type presentedScrapeConfig struct {
+	JobName              string          `yaml:"job_name"`
+	SampleLimit          int64           `yaml:"sample_limit,omitempty"`
+	MetricRelabelConfigs []yaml.MapSlice `yaml:"metric_relabel_configs,omitempty"`
+}
+
+// Read implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *MonitorConfigurator) Read() ([]byte, error) {
+	eps, err := c.endpoints()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	pc := presentedConfig{ScrapeConfigs: []presentedScrapeConfig{}}
+	for _, ep := range eps {
+		sc := presentedScrapeConfig{
+			JobName:     ep.job,
+			SampleLimit: ep.sampleLimit(),
+		}
+		for _, r := range ep.relabelings() {
+			rc, err := relabelConfigMapSlice(r)
+			if err != nil {
+				return []byte{}, fmt.Errorf("Bad metricRelabelings in %s %s/%s: %s", ep.Kind, ep.Namespace, ep.Name, err)
+			}
+			sc.MetricRelabelConfigs = append(sc.MetricRelabelConfigs, rc)
+		}
+		pc.ScrapeConfigs = append(pc.ScrapeConfigs, sc)
+	}
+
+	return yaml.Marshal(pc)
+}
+
+// Write implements github.com/Fresh-Tracks/bomb-squad/config.Configurator.
+// Only the metric_relabel_configs and sample_limit of the scrape configs are
+// written; the rest of the config is Prometheus Operator's business.
+func (c *MonitorConfigurator) Write(data []byte) error {
+	written := struct {
+		ScrapeConfigs []struct {
+			JobName              string                   `yaml:"job_name"`
+			SampleLimit          uint                     `yaml:"sample_limit,omitempty"`
+			MetricRelabelConfigs []*promcfg.RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+		} `yaml:"scrape_configs"`
+	}{}
+	err := yaml.Unmarshal(data, &written)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal Prometheus config to write to monitors: %s", err)
+	}
+
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// Retrieve the latest version of the monitors before every attempt
+		eps, err := c.endpoints()
+		if err != nil {
+			return err
+		}
+		byJob := map[string]*endpoint{}
+		for _, ep := range eps {
+			byJob[ep.job] = ep
+		}
+
+		changed := []*unstructured.Unstructured{}
+		seen := map[*unstructured.Unstructured]bool{}
+		markChanged := func(obj *unstructured.Unstructured) {
+			if !seen[obj] {
+				seen[obj] = true
+				changed = append(changed, obj)
+			}
+		}
+
+		// sampleLimit is per monitor rather than per endpoint, so the limits
+		// written for its endpoints are settled before it's set once. Every
+		// endpoint presents the monitor's current limit, so only those that
+		// differ from it were changed.
+		limits := map[*unstructured.Unstructured]int64{}
+		limitEndpoints := []*endpoint{}
+		for _, sc := range written.ScrapeConfigs {
+			ep, ok := byJob[sc.JobName]
+			if !ok {
+				log.Printf("No ServiceMonitor or PodMonitor endpoint for job %s any more, skipping it\n", sc.JobName)
+				continue
+			}
+
+			relabelingsChanged, err := ep.setRelabelings(sc.MetricRelabelConfigs)
+			if err != nil {
+				return err
+			}
+			if relabelingsChanged {
+				markChanged(ep.obj)
+			}
+
+			limit := int64(sc.SampleLimit)
+			if limit == ep.sampleLimit() {
+				continue
+			}
+			if previous, ok := limits[ep.obj]; ok {
+				limit = tighterSampleLimit(previous, limit)
+			} else {
+				limitEndpoints = append(limitEndpoints, ep)
+			}
+			limits[ep.obj] = limit
+		}
+		for _, ep := range limitEndpoints {
+			if ep.setSampleLimit(limits[ep.obj]) {
+				markChanged(ep.obj)
+			}
+		}
+
+		for _, obj := range changed {
+			err = c.Client.Update(obj)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if retryErr != nil {
+		return fmt.Errorf("Monitor update failed: %v", retryErr)
+	}
+
+	return nil
+}
+
+// endpoint is an Endpoint along with the object it's in, and the job it's
+// presented as
+type endpoint struct {
+	Endpoint
+	obj    *unstructured.Unstructured
+	fields map[string]interface{}
+	job    string
+}
+
+// endpoints lists the endpoints of every monitor, and names the jobs they're
+// presented as
+func (c *MonitorConfigurator) endpoints() ([]*endpoint, error) {
+	eps := []*endpoint{}
+	for _, kind := range Kinds {
+		objs, err := c.Client.List(kind)
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(objs, func(i, j int) bool {
+			if objs[i].GetNamespace() != objs[j].GetNamespace() {
+				return objs[i].GetNamespace() < objs[j].GetNamespace()
+			}
+			return objs[i].GetName() < objs[j].GetName()
+		})
+
+		for i := range objs {
+			obj := &objs[i]
+			obj.SetKind(kind)
+			obj.SetAPIVersion(apiGroupVersion)
+
+			items, _, _ := unstructured.NestedFieldNoCopy(obj.Object, "spec", endpointsFields[kind])
+			list, _ := items.([]interface{})
+			for idx, item := range list {
+				fields, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				eps = append(eps, &endpoint{
+					Endpoint: Endpoint{
+						Kind:      kind,
+						Namespace: obj.GetNamespace(),
+						Name:      obj.GetName(),
+						Index:     idx,
+					},
+					obj:    obj,
+					fields: fields,
+				})
+			}
+		}
+	}
+
+	poolJobs := map[string][]string{}
+	if c.ScrapePoolJobs != nil {
+		var err error
+		poolJobs, err = c.ScrapePoolJobs()
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't get the jobs of the monitors' scrape pools: %s", err)
+		}
+	}
+
+	claims := map[string]int{}
+	for _, ep := range eps {
+		jobs := poolJobs[ep.ScrapePool()]
+		if len(jobs) == 0 && ep.Kind == ServiceMonitor {
+			jobs = poolJobs[ep.legacyScrapePool()]
+		}
+		ep.job = ep.ScrapePool()
+		if len(jobs) == 1 {
+			ep.job = jobs[0]
+		}
+		claims[ep.job]++
+	}
+	// Endpoints sharing a job label can't be told apart by it
+	for _, ep := range eps {
+		if claims[ep.job] > 1 {
+			ep.job = ep.ScrapePool()
+		}
+	}
+
+	return eps, nil
+}
+
+func (ep *endpoint) relabelings() []interface{} {
+	r, _ := ep.fields["metricRelabelings"].([]interface{})
+	return r
+}
+
+func (ep *endpoint) sampleLimit() int64 {
+	limit, _, _ := unstructured.NestedInt64(ep.obj.Object, "spec", "sampleLimit")
+	return limit
+}
+
+// setRelabelings makes the endpoint's metricRelabelings match the given
+// relabel configs, keeping the existing ones as they were written
+func (ep *endpoint) setRelabelings(rcs []*promcfg.RelabelConfig) (bool, error) {
+	existing := ep.relabelings()
+	existingRules := make([]string, len(existing))
+	for i, r := range existing {
+		rc, err := relabelConfig(r)
+		if err != nil {
+			return false, fmt.Errorf("Bad metricRelabelings in %s %s/%s: %s", ep.Kind, ep.Namespace, ep.Name, err)
+		}
+		existingRules[i] = config.Fingerprint(*rc)
+	}
+
+	used := make([]bool, len(existing))
+	res := []interface{}{}
+	changed := len(rcs) != len(existing)
+	for i, rc := range rcs {
+		rule := config.Fingerprint(*rc)
+		if i < len(existingRules) && existingRules[i] != rule {
+			changed = true
+		}
+
+		var item interface{}
+		for j := range existing {
+			if !used[j] && existingRules[j] == rule {
+				used[j] = true
+				item = existing[j]
+				break
+			}
+		}
+		if item == nil {
+			item = relabeling(rc)
+		}
+		res = append(res, item)
+	}
+
+	if !changed {
+		return false, nil
+	}
+	if len(res) == 0 {
+		delete(ep.fields, "metricRelabelings")
+	} else {
+		ep.fields["metricRelabelings"] = res
+	}
+	return true, nil
+}
+
+// tighterSampleLimit returns the lower of two sample limits, where 0 means no
+// limit
+func tighterSampleLimit(a, b int64) int64 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+func (ep *endpoint) setSampleLimit(limit int64) bool {
+	if ep.sampleLimit() == limit {
+		return false
+	}
+	if limit == 0 {
+		unstructured.RemoveNestedField(ep.obj.Object, "spec", "sampleLimit")
+	} else {
+		unstructured.SetNestedField(ep.obj.Object, limit, "spec", "sampleLimit")
+	}
+	return true
+}
+
+// relabelingKeys pairs the keys of a Prometheus Operator RelabelConfig with
+// those of Prometheus's own
+var relabelingKeys = []struct {
+	operator, prometheus string
+}{
+	{"sourceLabels", "source_labels"},
+	{"separator", "separator"},
+	{"regex", "regex"},
+	{"modulus", "modulus"},
+	{"targetLabel", "target_label"},
+	{"replacement", "replacement"},
+	{"action", "action"},
+}
+
+// relabelConfigMapSlice turns one of a monitor's metricRelabelings into a
+// Prometheus relabel config
+func relabelConfigMapSlice(r interface{}) (yaml.MapSlice, error) {
+	m, ok := r.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("metricRelabelings item is a %T, not an object", r)
+	}
+
+	res := yaml.MapSlice{}
+	for _, k := range relabelingKeys {
+		if v, ok := m[k.operator]; ok {
+			res = append(res, yaml.MapItem{Key: k.prometheus, Value: v})
+		}
+	}
+	return res, nil
+}
+
+func relabelConfig(r interface{}) (*promcfg.RelabelConfig, error) {
+	m, err := relabelConfigMapSlice(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &promcfg.RelabelConfig{}
+	err = yaml.Unmarshal(b, rc)
+	return rc, err
+}
+
+// relabeling turns a Prometheus relabel config into a Prometheus Operator one
+func relabeling(rc *promcfg.RelabelConfig) map[string]interface{} {
+	res := map[string]interface{}{}
+	if len(rc.SourceLabels) > 0 {
+		labels := []interface{}{}
+		for _, l := range rc.SourceLabels {
+			labels = append(labels, string(l))
+		}
+		res["sourceLabels"] = labels
+	}
+	if rc.Separator != "" {
+		res["separator"] = rc.Separator
+	}
+	if regex, _ := rc.Regex.MarshalYAML(); regex != nil {
+		res["regex"] = regex
+	}
+	if rc.Modulus != 0 {
+		res["modulus"] = int64(rc.Modulus)
+	}
+	if rc.TargetLabel != "" {
+		res["targetLabel"] = rc.TargetLabel
+	}
+	if rc.Replacement != "" {
+		res["replacement"] = rc.Replacement
+	}
+	if rc.Action != "" {
+		res["action"] = string(rc.Action)
+	}
+	return res
+}
@@ -0,0 +1,185 @@
+package monitor_test
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/k8s/monitor"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	promcfg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fakeClient keeps monitors in memory
+type fakeClient struct {
+	objs    map[string][]unstructured.Unstructured
+	updates []string
+}
+
+func (f *fakeClient) List(kind string) ([]unstructured.Unstructured, error) {
+	res := []unstructured.Unstructured{}
+	for _, obj := range f.objs[kind] {
+		res = append(res, *obj.DeepCopy())
+	}
+	return res, nil
+}
+
+func (f *fakeClient) Update(obj *unstructured.Unstructured) error {
+	for i, o := range f.objs[obj.GetKind()] {
+		if o.GetNamespace() == obj.GetNamespace() && o.GetName() == obj.GetName() {
+			f.objs[obj.GetKind()][i] = *obj.DeepCopy()
+			f.updates = append(f.updates, obj.GetKind()+"/"+obj.GetName())
+		}
+	}
+	return nil
+}
+
+func (f *fakeClient) get(kind, name string) map[string]interface{} {
+	for _, o := range f.objs[kind] {
+		if o.GetName() == name {
+			return o.Object
+		}
+	}
+	return nil
+}
+
+func newMonitor(kind, name, endpointsField string, endpoints ...interface{}) unstructured.Unstructured {
+	return unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "monitoring.coreos.com/v1",
+		"kind":       kind,
+		"metadata": map[string]interface{}{
+			"namespace": "default",
+			"name":      name,
+		},
+		"spec": map[string]interface{}{
+			endpointsField: endpoints,
+		},
+	}}
+}
+
+func goGCRelabeling() map[string]interface{} {
+	return map[string]interface{}{
+		"sourceLabels": []interface{}{"__name__"},
+		"regex":        "go_gc_.*",
+		"action":       "Drop",
+	}
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{objs: map[string][]unstructured.Unstructured{
+		monitor.ServiceMonitor: {
+			newMonitor(monitor.ServiceMonitor, "app", "endpoints",
+				map[string]interface{}{"port": "web", "metricRelabelings": []interface{}{goGCRelabeling()}},
+				map[string]interface{}{"port": "admin"},
+			),
+		},
+		monitor.PodMonitor: {
+			newMonitor(monitor.PodMonitor, "pods", "podMetricsEndpoints", map[string]interface{}{"port": "metrics"}),
+		},
+	}}
+}
+
+func scrapePoolJobs() (map[string][]string, error) {
+	return map[string][]string{
+		"serviceMonitor/default/app/0": {"app"},
+		"default/app/1":                {"app-admin"},
+		"podMonitor/default/pods/0":    {"pods-a", "pods-b"},
+	}, nil
+}
+
+func TestPresentsMonitorsAsPromConfig(t *testing.T) {
+	c := monitor.NewMonitorConfigurator(newFakeClient(), scrapePoolJobs)
+
+	promConfig, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs, 3)
+	require.Equal(t, "app", promConfig.ScrapeConfigs[0].JobName)
+	require.Equal(t, "app-admin", promConfig.ScrapeConfigs[1].JobName)
+	require.Equal(t, "podMonitor/default/pods/0", promConfig.ScrapeConfigs[2].JobName)
+
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 1)
+	require.Equal(t, promcfg.RelabelDrop, promConfig.ScrapeConfigs[0].MetricRelabelConfigs[0].Action)
+	require.Empty(t, promConfig.ScrapeConfigs[1].MetricRelabelConfigs)
+}
+
+func TestSilencesGoToTheJobsMonitor(t *testing.T) {
+	f := newFakeClient()
+	c := monitor.NewMonitorConfigurator(f, scrapePoolJobs)
+
+	mrc, err := config.GenerateMetricRelabelConfig(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"})
+	require.NoError(t, err)
+	require.NoError(t, prom.ReUnmarshal(&mrc))
+
+	promConfig, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfg.RelabelConfig{mrc}, []string{"app"}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, []string{"ServiceMonitor/app"}, f.updates)
+
+	relabelings, _, err := unstructured.NestedSlice(f.get(monitor.ServiceMonitor, "app"), "spec", "endpoints")
+	require.NoError(t, err)
+	web := relabelings[0].(map[string]interface{})["metricRelabelings"].([]interface{})
+	require.Len(t, web, 2)
+	// Existing relabelings stay as they were written
+	require.Equal(t, goGCRelabeling(), web[0])
+	require.Equal(t, []interface{}{"__name__", "bar"}, web[1].(map[string]interface{})["sourceLabels"])
+	require.Equal(t, "replace", web[1].(map[string]interface{})["action"])
+	require.NotContains(t, relabelings[1], "metricRelabelings")
+
+	// Unsilencing takes it out again
+	promConfig, err = config.RemoveMetricRelabelConfigFromPromConfig([]promcfg.RelabelConfig{mrc}, c)
+	require.NoError(t, err)
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	require.Equal(t, newFakeClient().objs, f.objs)
+}
+
+func TestSampleLimitGoesToTheMonitor(t *testing.T) {
+	f := newFakeClient()
+	c := monitor.NewMonitorConfigurator(f, nil)
+
+	previous, err := config.SetSampleLimit(1000, []string{"podMonitor/default/pods/0"}, c)
+	require.NoError(t, err)
+	require.Equal(t, map[string]uint{"podMonitor/default/pods/0": 0}, previous)
+
+	limit, _, err := unstructured.NestedInt64(f.get(monitor.PodMonitor, "pods"), "spec", "sampleLimit")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), limit)
+	require.Equal(t, []string{"PodMonitor/pods"}, f.updates)
+}
+
+func TestSampleLimitOfMonitorWithSeveralEndpoints(t *testing.T) {
+	f := newFakeClient()
+	c := monitor.NewMonitorConfigurator(f, scrapePoolJobs)
+
+	// Only the first endpoint's job gets the limit, the second one's keeps
+	// what it read
+	_, err := config.SetSampleLimit(1000, []string{"app"}, c)
+	require.NoError(t, err)
+	limit, _, err := unstructured.NestedInt64(f.get(monitor.ServiceMonitor, "app"), "spec", "sampleLimit")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000), limit)
+
+	// And taking it off the first endpoint takes it off the monitor
+	_, err = config.SetSampleLimit(0, []string{"app"}, c)
+	require.NoError(t, err)
+	_, found, err := unstructured.NestedInt64(f.get(monitor.ServiceMonitor, "app"), "spec", "sampleLimit")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	// Conflicting changes settle on the tighter limit
+	_, err = config.SetSampleLimit(1000, []string{"app"}, c)
+	require.NoError(t, err)
+	promConfig, err := config.ReadPromConfig(c)
+	require.NoError(t, err)
+	promConfig.ScrapeConfigs[0].SampleLimit = 2000
+	promConfig.ScrapeConfigs[1].SampleLimit = 500
+	require.NoError(t, config.WritePromConfig(promConfig, c))
+	limit, _, err = unstructured.NestedInt64(f.get(monitor.ServiceMonitor, "app"), "spec", "sampleLimit")
+	require.NoError(t, err)
+	require.Equal(t, int64(500), limit)
+}
+
+func TestEndpointScrapePool(t *testing.T) {
+	e := monitor.Endpoint{Kind: monitor.PodMonitor, Namespace: "monitoring", Name: "kubelet", Index: 2}
+	require.Equal(t, "podMonitor/monitoring/kubelet/2", e.ScrapePool())
+}
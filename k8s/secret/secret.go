@@ -0,0 +1,80 @@
+package secret
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// SecretWrapper is a struct with public fields, which implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+type SecretWrapper struct {
+	// SecretInterface is a client, not the Secret itself
+	Client  kcorev1.SecretInterface
+	Name    string
+	DataKey string
+}
+
+// NewSecretWrapper returns a SecretWrapper
+func NewSecretWrapper(client kcorev1.SecretInterface, namespace string, secretName string, dataKey string) *SecretWrapper {
+	return &SecretWrapper{
+		Client:  client,
+		Name:    secretName,
+		DataKey: dataKey,
+	}
+}
+
+// GetLocation implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *SecretWrapper) GetLocation() string {
+	return c.DataKey
+}
+
+// Read implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *SecretWrapper) Read() ([]byte, error) {
+	dataKey := c.GetLocation()
+	s, err := c.Client.Get(c.Name, v1.GetOptions{})
+	if err != nil {
+		return []byte{}, fmt.Errorf("Failed to get Secret in preparation for Configurator.Read(): %s", err)
+	}
+
+	d := s.Data[dataKey]
+
+	return d, nil
+}
+
+// Write implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c *SecretWrapper) Write(data []byte) error {
+
+	dataKey := c.GetLocation()
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// Retrieve the latest version of Secret before attempting update
+		// RetryOnConflict uses exponential backoff to avoid exhausting the apiserver
+
+		s, err := c.Client.Get(c.Name, v1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("Failed to get latest version of Secret: %v", err)
+		}
+
+		if s.Data == nil {
+			s.Data = map[string][]byte{}
+		}
+		s.Data[dataKey] = data
+		// StringData takes precedence over Data on update, so a stale copy of
+		// the key there would undo our write
+		delete(s.StringData, dataKey)
+
+		_, updateErr := c.Client.Update(s)
+		if updateErr != nil {
+			return updateErr
+		}
+
+		return nil
+	})
+
+	if retryErr != nil {
+		return fmt.Errorf("Secret update failed: %v", retryErr)
+	}
+
+	return nil
+}
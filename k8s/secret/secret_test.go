@@ -0,0 +1,84 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	kCoreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	k8sTesting "k8s.io/client-go/testing"
+)
+
+func TestCanReadSecret(t *testing.T) {
+	sw := NewSecretWrapper(fakeSecretClient(), "testNamespace", "testSecret", "testDataKey")
+
+	s, _ := sw.Client.Create(newSecret())
+
+	b, err := sw.Read()
+
+	require.NoError(t, err)
+	require.Equal(t, s.Data["testDataKey"], b)
+}
+
+func TestCanWriteSecret(t *testing.T) {
+	sw := NewSecretWrapper(fakeSecretClient(), "testNamespace", "testSecret", "testDataKey")
+
+	_, _ = sw.Client.Create(newSecret())
+
+	err := sw.Write([]byte("BazBat"))
+	require.NoError(t, err)
+
+	b, err := sw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "BazBat", string(b))
+}
+
+func TestWriteRetriesOnConflict(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	conflicts := 2
+	clientset.PrependReactor("update", "secrets", func(action k8sTesting.Action) (bool, runtime.Object, error) {
+		if conflicts > 0 {
+			conflicts--
+			return true, nil, errors.NewConflict(schema.GroupResource{Resource: "secrets"}, "testSecret", nil)
+		}
+		return false, nil, nil
+	})
+
+	sw := NewSecretWrapper(clientset.CoreV1().Secrets("testNamespace"), "testNamespace", "testSecret", "testDataKey")
+	_, _ = sw.Client.Create(newSecret())
+
+	require.NoError(t, sw.Write([]byte("BazBat")))
+	require.Equal(t, 0, conflicts)
+
+	b, err := sw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "BazBat", string(b))
+}
+
+func fakeSecretClient() kCoreV1.SecretInterface {
+
+	return fake.NewSimpleClientset().CoreV1().Secrets("testNamespace")
+}
+
+func newSecret() *k8sAPICoreV1.Secret {
+	secretType := metaV1.TypeMeta{
+		Kind:       "Secret",
+		APIVersion: "core/v1",
+	}
+
+	objMeta := metaV1.ObjectMeta{
+		Name:      "testSecret",
+		Namespace: "testNamespace",
+	}
+
+	return &k8sAPICoreV1.Secret{
+		TypeMeta:   secretType,
+		ObjectMeta: objMeta,
+		Data:       map[string][]byte{"testDataKey": []byte("FooBar")},
+	}
+}
@@ -0,0 +1,98 @@
+package secret
+
+import (
+	"fmt"
+	"log"
+
+	k8sAPICoreV1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	kcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// EnsureSecret creates the named Secret if it doesn't exist yet
+func EnsureSecret(client kcorev1.SecretInterface, namespace string, name string) error {
+	_, err := client.Get(name, v1.GetOptions{})
+	if err == nil {
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return fmt.Errorf("Failed to get Secret %s: %s", name, err)
+	}
+
+	s := &k8sAPICoreV1.Secret{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"app.kubernetes.io/managed-by": "bomb-squad"},
+		},
+		Data: map[string][]byte{},
+	}
+
+	_, err = client.Create(s)
+	if errors.IsAlreadyExists(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to create Secret %s: %s", name, err)
+	}
+	log.Printf("Created Secret %s for Bomb Squad state\n", name)
+	return nil
+}
+
+// MoveDataKey moves a data key from one Secret to another, where it may go
+// by another key, the way configmap.MoveDataKey does for ConfigMaps
+func MoveDataKey(client kcorev1.SecretInterface, from string, to string, fromKey string, toKey string) error {
+	src, err := client.Get(from, v1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to get Secret %s: %s", from, err)
+	}
+	data, ok := src.Data[fromKey]
+	if !ok {
+		return nil
+	}
+
+	retryErr := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		dst, err := client.Get(to, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(dst.Data[toKey]) > 0 {
+			return nil
+		}
+		if dst.Data == nil {
+			dst.Data = map[string][]byte{}
+		}
+		dst.Data[toKey] = data
+
+		_, err = client.Update(dst)
+		return err
+	})
+	if retryErr != nil {
+		return fmt.Errorf("Failed to copy %s to Secret %s: %s", fromKey, to, retryErr)
+	}
+
+	retryErr = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		src, err := client.Get(from, v1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if _, ok := src.Data[fromKey]; !ok {
+			return nil
+		}
+		delete(src.Data, fromKey)
+
+		_, err = client.Update(src)
+		return err
+	})
+	if retryErr != nil {
+		return fmt.Errorf("Failed to remove %s from Secret %s: %s", fromKey, from, retryErr)
+	}
+
+	log.Printf("Moved %s from Secret %s to %s in %s\n", fromKey, from, toKey, to)
+	return nil
+}
@@ -0,0 +1,41 @@
+package secret
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEnsureSecret(t *testing.T) {
+	client := fakeSecretClient()
+	require.NoError(t, EnsureSecret(client, "testNamespace", "bomb-squad-state"))
+
+	sw := NewSecretWrapper(client, "testNamespace", "bomb-squad-state", "bomb-squad")
+	require.NoError(t, sw.Write([]byte("state")))
+
+	// An existing Secret is left alone
+	require.NoError(t, EnsureSecret(client, "testNamespace", "bomb-squad-state"))
+	b, err := sw.Read()
+	require.NoError(t, err)
+	require.Equal(t, "state", string(b))
+}
+
+func TestMoveSecretDataKey(t *testing.T) {
+	client := fakeSecretClient()
+	_, _ = client.Create(newSecret())
+	require.NoError(t, EnsureSecret(client, "testNamespace", "bomb-squad-state"))
+
+	require.NoError(t, MoveDataKey(client, "testSecret", "bomb-squad-state", "testDataKey", "testDataKey-a"))
+
+	src, err := client.Get("testSecret", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.NotContains(t, src.Data, "testDataKey")
+	dst, err := client.Get("bomb-squad-state", metaV1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "FooBar", string(dst.Data["testDataKey-a"]))
+
+	// Nothing left to move
+	require.NoError(t, MoveDataKey(client, "testSecret", "bomb-squad-state", "testDataKey", "testDataKey-a"))
+	require.NoError(t, MoveDataKey(client, "missing", "bomb-squad-state", "testDataKey", "testDataKey-a"))
+}
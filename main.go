@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io/ioutil"
@@ -8,16 +9,27 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/file"
 	configmap "github.com/Fresh-Tracks/bomb-squad/k8s/configmap"
+	"github.com/Fresh-Tracks/bomb-squad/k8s/events"
+	"github.com/Fresh-Tracks/bomb-squad/k8s/lease"
+	"github.com/Fresh-Tracks/bomb-squad/k8s/monitor"
+	"github.com/Fresh-Tracks/bomb-squad/k8s/secret"
 	"github.com/Fresh-Tracks/bomb-squad/patrol"
 	"github.com/Fresh-Tracks/bomb-squad/prom"
 	"github.com/Fresh-Tracks/bomb-squad/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	coordinationv1beta1 "k8s.io/client-go/kubernetes/typed/coordination/v1beta1"
 	"k8s.io/client-go/rest"
 )
 
@@ -28,10 +40,37 @@ var (
 	inK8s              = flag.Bool("k8s", true, "Whether bomb-squad is being deployed in a Kubernetes cluster")
 	k8sNamespace       = flag.String("k8s-namespace", "default", "Kubernetes namespace holding Prometheus ConfigMap")
 	k8sConfigMapName   = flag.String("k8s-configmap", "prometheus", "Name of the Kubernetes ConfigMap holding Prometheus configuration")
+	bsConfigMapName    = flag.String("bs-configmap", "bomb-squad-state", "Name of the Kubernetes ConfigMap holding the Bomb Squad config, created if missing. Empty keeps it in -k8s-configmap, next to the Prometheus config.")
+	k8sSecretName      = flag.String("k8s-secret", "prometheus", "Name of the Kubernetes Secret holding Prometheus configuration, for configs kept in a Secret")
+	promConfigKind     = flag.String("prom-config-kind", "configmap", "Kind of Kubernetes object holding the Prometheus config. One of configmap, secret or monitors. With monitors, silences go in the metricRelabelings of the Prometheus Operator ServiceMonitors and PodMonitors instead.")
+	monitorNamespace   = flag.String("monitor-namespace", "", "Namespace holding the ServiceMonitors and PodMonitors, with -prom-config-kind=monitors. Empty means all namespaces.")
+	bsSecretName       = flag.String("bs-secret", "", "Name of the Kubernetes Secret holding the Bomb Squad config with -bs-config-kind=secret, created if missing. Defaults to -bs-configmap. If both are empty, it's kept in -k8s-secret, next to the Prometheus config.")
+	bsConfigKind       = flag.String("bs-config-kind", "configmap", "Kind of Kubernetes object holding the Bomb Squad config. One of configmap or secret.")
 	bsConfigLocation   = flag.String("bs-config-loc", "bomb-squad", "Where the Bomb Squad Config lives. For K8s deployments, this should be the ConfigMap.Data key. Otherwise, full path to file.")
+	journalLocation    = flag.String("journal-loc", "bomb-squad-journal", "Where the journal of config changes in progress lives, next to the Bomb Squad config, or the Prometheus config if that's kept in a Secret. For K8s deployments, this should be the ConfigMap.Data key. Otherwise, full path to file. Empty disables the journal.")
 	promConfigLocation = flag.String("prom-config-loc", "prometheus.yml", "Where the Prometheus lives. For K8s deployments, this should be the ConfigMap.Data key. Otherwise, full path to file.")
 	metricsPort        = flag.Int("metrics-port", 8080, "Port on which to listen for metric scrapes")
+	targetsFile        = flag.String("targets-file", "", "YAML file listing several Prometheus targets, ex. the shards of a sharded Prometheus, each with its own name, url, config_map (or secret, or config_file outside Kubernetes) and thresholds. Empty watches the one Prometheus set by the other flags.")
+	targetName         = flag.String("target", "", "Name of the target in -targets-file that commands such as list and unsilence act on. Empty means the first one.")
+	propagateSilences  = flag.Bool("propagate-silences", false, "Whether to copy every silence put in place for one target to the others, so a metric that moves between shards stays silenced")
 	promURL            = flag.String("prom-url", "http://localhost:9090", "Prometheus URL to query")
+	scopeLabels        = flag.String("scope-labels", "", "Comma-separated labels (ex. namespace,pod) used to limit silences to the targets responsible for an explosion")
+	escalationGrace    = flag.Duration("escalation-grace-period", 2*time.Minute, "How long a silence gets to stop an explosion before stronger action is taken. 0 disables escalation.")
+	escalationLimit    = flag.Uint("escalation-sample-limit", 0, "sample_limit to set on a job's scrape config when nothing else stops one of its metrics exploding. 0 skips this step.")
+	metricNameInterval = flag.Duration("metric-name-interval", time.Minute, "How often to look for surges in metric names, which takes a query per job. Growth is counted since the last look. 0 looks every patrol.")
+	reconcileInterval  = flag.Duration("reconcile-interval", 5*time.Minute, "How often to check that the silences Bomb Squad recorded are still in the Prometheus config, and put back any that went missing. 0 disables reconciliation.")
+	leaderElect        = flag.Bool("leader-elect", false, "Whether to elect a leader among Bomb Squad replicas, ex. the sidecars of a Prometheus HA pair, through a Kubernetes Lease. Only the leader changes configs; the others only observe.")
+	leaderElectLease   = flag.String("leader-elect-lease", "bomb-squad", "Name of the Lease used for leader election, in -k8s-namespace")
+	breakerThreshold   = flag.Int("breaker-threshold", 3, "How many patrols in a row can fail to query Prometheus, each after retrying, before patrols are skipped for -breaker-cooldown. 0 never skips a patrol.")
+	breakerCooldown    = flag.Duration("breaker-cooldown", time.Minute, "How long to skip patrols once Prometheus has kept failing")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", 30*time.Second, "How long to wait on SIGTERM or SIGINT for patrols to finish writing their changes and for metric scrapes under way")
+	leaderElectTTL     = flag.Duration("leader-elect-lease-duration", 15*time.Second, "How long the leader's Lease lasts without being renewed before another replica takes over")
+	reload             = flag.Bool("reload", true, "Whether to reload Prometheus, and check the reload took effect, after changing its config")
+	reloadConfigFile   = flag.String("reload-config-file", "", "Where the Prometheus config is mounted, if Bomb Squad can see it too. Reloads wait for the written config to show up there first.")
+	reloadSyncTimeout  = flag.Duration("reload-sync-timeout", 2*time.Minute, "How long to wait for the written Prometheus config to show up in -reload-config-file, or without it, for Prometheus to load it")
+	reloadVerifyTime   = flag.Duration("reload-verify-timeout", 30*time.Second, "How long Prometheus gets to report the written config as loaded")
+	bootstrapRules     = flag.String("bootstrap-rules", "/etc/bomb-squad/rules.yaml", "Bomb Squad's recording rules, to be copied to -rules-file on startup")
+	rulesFile          = flag.String("rules-file", "/etc/config/bomb-squad/rules.yaml", "Where Prometheus loads Bomb Squad's recording rules from")
 	getVersion         = flag.Bool("version", false, "return version information and exit")
 	versionGauge       = prometheus.NewGauge(
 		prometheus.GaugeOpts{
@@ -45,38 +84,325 @@ var (
 			},
 		},
 	)
-	k8sClientSet     kubernetes.Interface
-	promConfigurator config.Configurator
-	bsConfigurator   config.Configurator
+	k8sClientSet kubernetes.Interface
 )
 
+// target is a Prometheus watched by this Bomb Squad, with its patrol
+type target struct {
+	patrol *patrol.Patrol
+	// operated is set when Prometheus Operator generates the target's config
+	operated bool
+	// configMaps are its Configurators backed by ConfigMaps, to be served
+	// from informers once Bomb Squad runs as a sidecar
+	configMaps []*configmap.ConfigMapWrapper
+}
+
 func init() {
 	prometheus.MustRegister(versionGauge)
 	prometheus.MustRegister(patrol.ExplodingLabelGauge)
+	prometheus.MustRegister(patrol.ExplodingLabelNamesGauge)
+	prometheus.MustRegister(patrol.ExplodingMetricNamesGauge)
+	prometheus.MustRegister(prom.ReloadsCounter)
+	prometheus.MustRegister(prom.LastReloadSuccessfulGauge)
+	prometheus.MustRegister(prom.RollbacksCounter)
+	prometheus.MustRegister(patrol.DriftMissingRulesGauge)
+	prometheus.MustRegister(patrol.DriftUnexpectedRulesGauge)
+	prometheus.MustRegister(patrol.DriftReappliedCounter)
+	prometheus.MustRegister(lease.LeaderGauge)
+	prometheus.MustRegister(patrol.PrometheusErrorsCounter)
+	prometheus.MustRegister(patrol.PatrolFailuresCounter)
+	prometheus.MustRegister(patrol.PatrolsSkippedCounter)
+	prometheus.MustRegister(patrol.CircuitOpenGauge)
 }
 
-func bootstrap(c config.Configurator) {
+// bootstrap copies Bomb Squad's recording rules to -rules-file and adds it to
+// the Prometheus config
+func bootstrap(c config.Configurator) error {
 	// TODO: Don't do this file write if the file already exists, but DO write the file
 	// if it's not present on disk but still present in the ConfigMap
-	b, err := ioutil.ReadFile("/etc/bomb-squad/rules.yaml")
+	b, err := ioutil.ReadFile(*bootstrapRules)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	err = ioutil.WriteFile("/etc/config/bomb-squad/rules.yaml", b, 0644)
+	err = ioutil.WriteFile(*rulesFile, b, 0644)
 	if err != nil {
-		log.Fatalf("Error writing bootstrap recording rules: %s", err)
+		return fmt.Errorf("Error writing bootstrap recording rules: %s", err)
 	}
 
-	cfg, err := prom.AppendRuleFile("/etc/config/bomb-squad/rules.yaml", c)
+	cfg, err := prom.AppendRuleFile(*rulesFile, c)
 	if err != nil {
-		log.Fatalf("Error adding bootstrap recording rules to Prometheus config: %s", err)
+		return fmt.Errorf("Error adding bootstrap recording rules to Prometheus config: %s", err)
 	}
 
 	err = config.WritePromConfig(cfg, c)
 	if err != nil {
-		log.Fatalf("Error adding bootstrap recording rules to Prometheus config: %s", err)
+		return fmt.Errorf("Error adding bootstrap recording rules to Prometheus config: %s", err)
 	}
+	return nil
+}
 
+// k8sConfigurator returns a Configurator for the data key of the named
+// ConfigMap or Secret, depending on kind
+func k8sConfigurator(kind, configMapName, secretName, dataKey string) config.Configurator {
+	switch kind {
+	case "configmap":
+		return configmap.NewConfigMapWrapper(k8sClientSet.CoreV1().ConfigMaps(*k8sNamespace), *k8sNamespace, configMapName, dataKey)
+	case "secret":
+		return secret.NewSecretWrapper(k8sClientSet.CoreV1().Secrets(*k8sNamespace), *k8sNamespace, secretName, dataKey)
+	}
+	log.Fatalf("Unknown config kind '%s', expected configmap or secret", kind)
+	return nil
+}
+
+// setUpStateConfigMap creates the ConfigMap holding the Bomb Squad config,
+// owned by the first target's Prometheus ConfigMap if there is one, and moves
+// over the state that earlier versions kept in each target's ConfigMap
+func setUpStateConfigMap(name string, targets []config.Target) {
+	client := k8sClientSet.CoreV1().ConfigMaps(*k8sNamespace)
+
+	var owner *metav1.OwnerReference
+	if *promConfigKind == "configmap" && targets[0].ConfigMap != name {
+		var err error
+		owner, err = configmap.OwnerReference(client, targets[0].ConfigMap)
+		if err != nil {
+			log.Fatalf("Couldn't set up Bomb Squad state ConfigMap: %s", err)
+		}
+	}
+
+	err := configmap.EnsureConfigMap(client, *k8sNamespace, name, owner)
+	if err != nil {
+		log.Fatalf("Couldn't set up Bomb Squad state ConfigMap: %s", err)
+	}
+
+	for _, t := range targets {
+		if t.ConfigMap == "" || t.ConfigMap == name {
+			continue
+		}
+		err = configmap.MoveDataKey(client, t.ConfigMap, name, *bsConfigLocation, targetKey(*bsConfigLocation, t.Name))
+		if err != nil {
+			log.Fatalf("Couldn't move Bomb Squad state out of ConfigMap %s: %s", t.ConfigMap, err)
+		}
+	}
+}
+
+// stateSecretName returns the name of the Secret holding the Bomb Squad
+// config with -bs-config-kind=secret, if it has one of its own
+func stateSecretName() string {
+	if *bsSecretName != "" {
+		return *bsSecretName
+	}
+	return *bsConfigMapName
+}
+
+// setUpStateSecret creates the Secret holding the Bomb Squad config, and
+// moves over the state that earlier versions kept in each target's Prometheus
+// Secret
+func setUpStateSecret(name string, targets []config.Target) {
+	client := k8sClientSet.CoreV1().Secrets(*k8sNamespace)
+
+	err := secret.EnsureSecret(client, *k8sNamespace, name)
+	if err != nil {
+		log.Fatalf("Couldn't set up Bomb Squad state Secret: %s", err)
+	}
+
+	for _, t := range targets {
+		if t.Secret == "" || t.Secret == name {
+			continue
+		}
+		err = secret.MoveDataKey(client, t.Secret, name, *bsConfigLocation, targetKey(*bsConfigLocation, t.Name))
+		if err != nil {
+			log.Fatalf("Couldn't move Bomb Squad state out of Secret %s: %s", t.Secret, err)
+		}
+	}
+}
+
+// watchConfigMaps serves reads of the ConfigMaps holding the configs from
+// informers, one per ConfigMap, and has the patrol of a target reconcile
+// whenever someone else changes its configs
+func watchConfigMaps(targets []target, stopCh <-chan struct{}) {
+	informers := map[string]*configmap.Informer{}
+	for _, t := range targets {
+		for _, w := range t.configMaps {
+			informer, ok := informers[w.Name]
+			if !ok {
+				informer = configmap.NewInformer(w.Client, *k8sNamespace, w.Name)
+				if !informer.Run(stopCh, 30*time.Second) {
+					log.Printf("ConfigMap %s isn't cached yet, reading it from the API server until it is. Bomb Squad's service account needs list and watch on ConfigMaps.\n", w.Name)
+				}
+				informers[w.Name] = informer
+			}
+			w.Informer = informer
+			w.OnExternalChange(t.patrol.RequestReconcile)
+		}
+	}
+}
+
+// targetKey returns where a target's copy of a config goes when several
+// targets share the object holding it
+func targetKey(location, name string) string {
+	if name == "" {
+		return location
+	}
+	return location + "-" + name
+}
+
+// loadTargets returns the targets listed in -targets-file, or else the one
+// Prometheus set by the other flags
+func loadTargets() []config.Target {
+	if *targetsFile == "" {
+		return []config.Target{config.Target{
+			URL:        *promURL,
+			ConfigMap:  *k8sConfigMapName,
+			Secret:     *k8sSecretName,
+			ConfigFile: *promConfigLocation,
+		}.WithDefaults()}
+	}
+
+	b, err := ioutil.ReadFile(*targetsFile)
+	if err != nil {
+		log.Fatalf("Couldn't read targets: %s", err)
+	}
+	targets, err := config.LoadTargets(b)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return targets
+}
+
+// newTarget sets up the configs of a Prometheus target, and a patrol to watch
+// over it
+func newTarget(ctx context.Context, t config.Target, httpClient *http.Client) target {
+	promurl, err := url.Parse(t.URL)
+	if err != nil {
+		log.Fatalf("could not parse prometheus url: %s", err)
+	}
+
+	var (
+		promConfigurator config.Configurator
+		bsConfigurator   config.Configurator
+		journal          config.Configurator
+		eventSink        prom.EventSink
+	)
+	if *inK8s {
+		if *promConfigKind == "monitors" {
+			promConfigurator = monitor.NewMonitorConfigurator(
+				monitor.NewRESTClient(k8sClientSet.Discovery().RESTClient(), *monitorNamespace),
+				func() (map[string][]string, error) { return prom.ScrapePoolJobs(ctx, promurl, httpClient) },
+			)
+		} else {
+			if (*promConfigKind == "configmap" && t.ConfigMap == "") || (*promConfigKind == "secret" && t.Secret == "") {
+				log.Fatalf("Target %s has no %s holding its Prometheus config", t.Name, *promConfigKind)
+			}
+			promConfigurator = k8sConfigurator(*promConfigKind, t.ConfigMap, t.Secret, *promConfigLocation)
+		}
+		// State goes next to the Prometheus config, unless it has a ConfigMap
+		// or Secret of its own, shared by all targets
+		bsConfigMap, bsSecret, bsKey, journalKey := t.ConfigMap, t.Secret, *bsConfigLocation, *journalLocation
+		switch {
+		case *bsConfigKind == "configmap" && *bsConfigMapName != "" && *bsConfigMapName != t.ConfigMap:
+			bsConfigMap, bsKey, journalKey = *bsConfigMapName, targetKey(*bsConfigLocation, t.Name), targetKey(*journalLocation, t.Name)
+		case *bsConfigKind == "secret" && stateSecretName() != "" && stateSecretName() != t.Secret:
+			bsSecret, bsKey, journalKey = stateSecretName(), targetKey(*bsConfigLocation, t.Name), targetKey(*journalLocation, t.Name)
+		}
+		if *bsConfigKind == "secret" && bsSecret == "" {
+			log.Fatalf("Target %s has no Secret to keep Bomb Squad state in, set -bs-secret", t.Name)
+		}
+		bsConfigurator = k8sConfigurator(*bsConfigKind, bsConfigMap, bsSecret, bsKey)
+		if *journalLocation != "" {
+			// The journal holds copies of the Prometheus config, so it has to be
+			// kept as safe as the original
+			if *promConfigKind == "secret" && *bsConfigKind != "secret" {
+				journal = k8sConfigurator("secret", bsConfigMap, t.Secret, targetKey(*journalLocation, t.Name))
+			} else {
+				journal = k8sConfigurator(*bsConfigKind, bsConfigMap, bsSecret, journalKey)
+			}
+		}
+		if *promConfigKind == "secret" {
+			eventSink = events.NewSecretEventSink(k8sClientSet.CoreV1().Events(*k8sNamespace), *k8sNamespace, t.Secret)
+		} else {
+			eventSink = events.NewConfigMapEventSink(k8sClientSet.CoreV1().Events(*k8sNamespace), *k8sNamespace, t.ConfigMap)
+		}
+	} else {
+		if _, err := os.Stat(t.ConfigFile); err != nil {
+			log.Fatalf("Couldn't find Prometheus config: %s", err)
+		}
+		promConfigurator = file.NewFileConfigurator(t.ConfigFile)
+		bsConfigurator = file.NewFileConfigurator(targetKey(*bsConfigLocation, t.Name))
+		if *journalLocation != "" {
+			journal = file.NewFileConfigurator(targetKey(*journalLocation, t.Name))
+		}
+	}
+
+	res := target{
+		// Prometheus Operator reloads Prometheus itself once it has regenerated
+		// the config from the monitors
+		operated: *inK8s && *promConfigKind == "monitors",
+	}
+	for _, c := range []config.Configurator{promConfigurator, bsConfigurator, journal} {
+		if w, ok := c.(*configmap.ConfigMapWrapper); ok {
+			res.configMaps = append(res.configMaps, w)
+		}
+	}
+
+	if *reload && !res.operated && promConfigurator != nil {
+		promConfigurator = prom.ReloadingConfigurator{
+			Configurator: promConfigurator,
+			Reloader: &prom.Reloader{
+				PromURL:       promurl,
+				HTTPClient:    httpClient,
+				ConfigFile:    *reloadConfigFile,
+				SyncTimeout:   *reloadSyncTimeout,
+				VerifyTimeout: *reloadVerifyTime,
+			},
+			Events:  eventSink,
+			Context: ctx,
+		}
+	}
+
+	res.patrol = &patrol.Patrol{
+		Name:                      t.Name,
+		PromURL:                   promurl,
+		Interval:                  5 * time.Second,
+		HighCardN:                 t.HighCardN,
+		HighCardThreshold:         t.HighCardThreshold,
+		LabelNameGrowthThreshold:  t.LabelNameGrowthThreshold,
+		MetricNameGrowthThreshold: t.MetricNameGrowthThreshold,
+		MetricNameInterval:        *metricNameInterval,
+		ScopeLabels:               splitFlag(*scopeLabels),
+		EscalationGracePeriod:     *escalationGrace,
+		EscalationSampleLimit:     *escalationLimit,
+		ReconcileInterval:         *reconcileInterval,
+		HTTPClient:                httpClient,
+		Retry:                     prom.DefaultBackoff,
+		BreakerThreshold:          *breakerThreshold,
+		BreakerCooldown:           *breakerCooldown,
+		PromConfigurator:          promConfigurator,
+		BSConfigurator:            bsConfigurator,
+		Journal:                   journal,
+		JournalGracePeriod:        journalGracePeriod(promConfigurator),
+	}
+	return res
+}
+
+// journalGracePeriod gives changes in the journal long enough to be written by
+// promConfigurator, waiting on reloads included, before they're recovered
+func journalGracePeriod(promConfigurator config.Configurator) time.Duration {
+	rc, ok := promConfigurator.(prom.ReloadingConfigurator)
+	if !ok {
+		return patrol.DefaultJournalGracePeriod
+	}
+	return rc.WriteTimeout() + patrol.DefaultJournalGracePeriod
+}
+
+// splitFlag turns a comma-separated flag value into its non-empty parts
+func splitFlag(s string) []string {
+	res := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
 }
 
 func main() {
@@ -86,6 +412,12 @@ func main() {
 		log.Fatal(out)
 	}
 
+	httpClient, err := util.HttpClient()
+	if err != nil {
+		log.Fatalf("could not create http client: %s", err)
+	}
+
+	configs := loadTargets()
 	if *inK8s {
 		inClusterConfig, err := rest.InClusterConfig()
 		if err != nil {
@@ -96,55 +428,196 @@ func main() {
 		if err != nil {
 			log.Fatal(err)
 		}
-		cmClient := k8sClientSet.CoreV1().ConfigMaps(*k8sNamespace)
-		promConfigurator = configmap.NewConfigMapWrapper(cmClient, *k8sNamespace, *k8sConfigMapName, *promConfigLocation)
-		bsConfigurator = configmap.NewConfigMapWrapper(cmClient, *k8sNamespace, *k8sConfigMapName, *bsConfigLocation)
+		if *bsConfigKind == "configmap" && *bsConfigMapName != "" {
+			setUpStateConfigMap(*bsConfigMapName, configs)
+		}
+		if *bsConfigKind == "secret" && stateSecretName() != "" {
+			setUpStateSecret(stateSecretName(), configs)
+		}
 	}
 
-	promurl, err := url.Parse(*promURL)
-	if err != nil {
-		log.Fatalf("could not parse prometheus url: %s", err)
-	}
+	// Cancelled on SIGTERM or SIGINT, see below
+	ctx, cancel := context.WithCancel(context.Background())
 
-	httpClient, err := util.HttpClient()
-	if err != nil {
-		log.Fatalf("could not create http client: %s", err)
+	// Each target's configs have a lock of their own, which peers propagating
+	// silences to them take as well
+	targets := []target{}
+	for _, c := range configs {
+		t := newTarget(ctx, c, httpClient)
+		t.patrol.WriteLock = &sync.Mutex{}
+		targets = append(targets, t)
+	}
+	if *propagateSilences {
+		for _, t := range targets {
+			for _, peer := range targets {
+				if peer.patrol != t.patrol {
+					t.patrol.Peers = append(t.patrol.Peers, peer.patrol)
+				}
+			}
+		}
 	}
 
-	p := patrol.Patrol{
-		PromURL:           promurl,
-		Interval:          5 * time.Second,
-		HighCardN:         5,
-		HighCardThreshold: 100,
-		HTTPClient:        httpClient,
-		PromConfigurator:  promConfigurator,
-		BSConfigurator:    bsConfigurator,
+	// Commands act on one target
+	p := targets[0].patrol
+	if *targetName != "" {
+		p = nil
+		for _, t := range targets {
+			if t.patrol.Name == *targetName {
+				p = t.patrol
+			}
+		}
+		if p == nil {
+			log.Fatalf("No target named %s in %s", *targetName, *targetsFile)
+		}
 	}
 
 	if len(os.Args) > 1 {
 		cmd := os.Args[1]
 		if cmd == "list" {
 			fmt.Println("Suppressed Labels (metricName.labelName):")
-			config.ListSuppressedMetrics(p.BSConfigurator)
+			err := config.ListSuppressedMetrics(p.BSConfigurator)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = config.ListIncidents(p.BSConfigurator)
+			if err != nil {
+				log.Fatal(err)
+			}
 			os.Exit(0)
 		}
 
 		if cmd == "unsilence" {
 			label := os.Args[2]
 			fmt.Printf("Removing silence rule for suppressed label: %s\n", label)
-			err := config.RemoveSilence(label, p.PromConfigurator, p.BSConfigurator)
+			err := p.Journaled("unsilence "+label, func(pc, bc config.Configurator) error {
+				return config.RemoveSilence(label, pc, bc)
+			})
 			if err != nil {
 				log.Fatalf("Could not remove silencing rule: %s\n", err)
 			}
 
 			os.Exit(0)
 		}
+
+		if cmd == "silence" {
+			label := os.Args[2]
+			fmt.Printf("Silencing label: %s\n", label)
+			err := p.Journaled("silence "+label, func(pc, bc config.Configurator) error {
+				return p.SilenceLabel(label, pc, bc)
+			})
+			if err != nil {
+				log.Fatalf("Could not silence label: %s\n", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if cmd == "resolve" {
+			metric := os.Args[2]
+			fmt.Printf("Resolving incident for metric: %s\n", metric)
+			err := p.Journaled("resolve "+metric, func(pc, bc config.Configurator) error {
+				return config.ResolveIncident(metric, pc, bc)
+			})
+			if err != nil {
+				log.Fatalf("Could not resolve incident: %s\n", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if cmd == "refresh" {
+			label := os.Args[2]
+			fmt.Printf("Refreshing kept values for suppressed label: %s\n", label)
+			err := p.Journaled("refresh "+label, func(pc, bc config.Configurator) error {
+				return p.RefreshSilence(label, pc, bc)
+			})
+			if err != nil {
+				log.Fatalf("Could not refresh silencing rule: %s\n", err)
+			}
+
+			os.Exit(0)
+		}
+
+		if cmd == "gc" {
+			dryRun := len(os.Args) > 2 && os.Args[2] == "--dry-run"
+			var (
+				g   config.Garbage
+				err error
+			)
+			if dryRun {
+				g, err = config.FindGarbage(p.PromConfigurator, p.BSConfigurator)
+			} else {
+				err = p.Journaled("gc", func(pc, bc config.Configurator) error {
+					var err error
+					g, err = config.CollectGarbage(pc, bc)
+					return err
+				})
+			}
+			if err != nil {
+				log.Fatalf("Could not collect orphaned silences: %s\n", err)
+			}
+			fmt.Print(g)
+			if dryRun && !g.Empty() {
+				fmt.Println("Dry run, nothing removed")
+			}
+
+			os.Exit(0)
+		}
 	}
 
+	// Stop patrolling on SIGTERM or SIGINT, once whatever is being written is
+	// done
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("Got %s, shutting down\n", sig)
+		cancel()
+	}()
+
 	if *inK8s {
-		bootstrap(p.PromConfigurator)
+		watchConfigMaps(targets, ctx.Done())
+	}
+
+	if *inK8s && *leaderElect {
+		identity, err := os.Hostname()
+		if err != nil {
+			log.Fatalf("Couldn't tell replicas apart for leader election: %s", err)
+		}
+		inClusterConfig, err := rest.InClusterConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		coordinationClient, err := coordinationv1beta1.NewForConfig(inClusterConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		elector := lease.NewElector(coordinationClient.Leases(*k8sNamespace), *k8sNamespace, *leaderElectLease, identity, *leaderElectTTL)
+		for _, t := range targets {
+			t.patrol.IsLeader = elector.IsLeader
+		}
+		go elector.Run(ctx.Done())
+	}
+
+	patrols := sync.WaitGroup{}
+	for _, t := range targets {
+		p := t.patrol
+
+		// Bootstrapped by the first patrol this replica leads, which also
+		// recovers whatever was being written when Bomb Squad last stopped
+		if t.operated {
+			log.Println("Prometheus Operator manages rule_files, so Bomb Squad's recording rules have to be installed as a PrometheusRule")
+		} else {
+			p.Bootstrap = func() error {
+				return bootstrap(p.PromConfigurator)
+			}
+		}
+		patrols.Add(1)
+		go func() {
+			defer patrols.Done()
+			p.Run(ctx)
+		}()
 	}
-	go p.Run()
 
 	mux := http.DefaultServeMux
 	mux.Handle("/metrics", promhttp.Handler())
@@ -158,5 +631,31 @@ func main() {
 
 	fmt.Println("Welcome to bomb-squad")
 	log.Println("serving prometheus endpoints on port 8080")
-	log.Fatal(server.ListenAndServe())
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelShutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		patrols.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-shutdownCtx.Done():
+		log.Println("Gave up waiting for patrols to stop. Changes they were writing are recovered from the journal, if there is one, on the next start.")
+	}
+
+	err = server.Shutdown(shutdownCtx)
+	if err != nil {
+		log.Printf("Couldn't shut down the metrics server cleanly: %s\n", err)
+	}
+	log.Println("bomb-squad stopped")
 }
@@ -1,10 +1,10 @@
 package patrol
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"sort"
 	"strconv"
 
 	"github.com/Fresh-Tracks/bomb-squad/config"
@@ -12,7 +12,7 @@ import (
 	"github.com/deckarep/golang-set"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
-	yaml "gopkg.in/yaml.v2"
+	promcfg "github.com/prometheus/prometheus/config"
 )
 
 var (
@@ -30,8 +30,11 @@ var (
 // label within a single metric's collection of series
 type labelTracker map[string]mapset.Set
 
-func (p *Patrol) getTopCardinalities() error {
-	var highCardSeries []config.HighCardSeries
+func (p *Patrol) getTopCardinalities(pc, bc config.Configurator) error {
+	var (
+		highCardSeries []config.HighCardSeries
+		explodingNames []config.ExplodingLabelNames
+	)
 
 	relativeURL, err := url.Parse("/api/v1/query")
 	if err != nil {
@@ -44,57 +47,92 @@ func (p *Patrol) getTopCardinalities() error {
 
 	queryURL := p.PromURL.ResolveReference(relativeURL)
 
-	b, err := prom.Fetch(queryURL.String(), p.HTTPClient)
-	if err != nil {
-		return fmt.Errorf("failed to fetch query from prometheus: %s", err)
-	}
-
 	iq := &prom.InstantQuery{}
-	err = json.Unmarshal(b, iq)
+	err = p.fetchJSON(queryURL.String(), iq)
 	if err != nil {
-		// Bail here because we're cooked if we can't wrangle Prometheus output
-		log.Fatal(err)
+		return err
 	}
 
 	m := p.cardinalityTooHigh(iq)
 	if len(m) > 0 {
-		highCardSeries = p.findHighCardSeries(m)
+		highCardSeries, explodingNames, err = p.findHighCardSeries(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = p.observeTopMetrics(iq, m)
+	if err != nil {
+		return err
 	}
 
 	for _, s := range highCardSeries {
-		mrc, err := config.GenerateMetricRelabelConfig(s)
+		if p.hasOpenIncident(s.MetricName, bc) {
+			// The escalation ladder decides what happens next
+			continue
+		}
+
+		suppressor, err := p.suppressorFor(s.MetricName, bc)
 		if err != nil {
-			log.Printf("Couldn't generate metric relabel config for metric %s: %s\n", s.MetricName, err)
+			log.Printf("Couldn't pick a suppression strategy for metric %s: %s\n", s.MetricName, err)
 			continue
 		}
 
-		err = prom.ReUnmarshal(&mrc)
+		err = p.silenceSeries(s, suppressor, pc, bc)
 		if err != nil {
-			log.Println(err)
+			log.Printf("Couldn't silence metric %s: %s\n", s.MetricName, err)
 			continue
 		}
 
-		newPromConfig, err := config.InsertMetricRelabelConfigToPromConfig(mrc, p.PromConfigurator)
+		err = p.openIncident(s, bc)
 		if err != nil {
-			log.Printf("Error inserting relabel config for metric %s: %s\n", s.MetricName, err)
+			log.Printf("Couldn't open incident for metric %s: %s\n", s.MetricName, err)
+		}
+	}
+
+	for _, e := range explodingNames {
+		mrc, err := config.GenerateLabelDropRelabelConfig(e)
+		if err != nil {
+			log.Printf("Couldn't generate labeldrop relabel config for metric %s: %s\n", e.MetricName, err)
 			continue
 		}
 
-		newPromConfigBytes, err := yaml.Marshal(newPromConfig)
+		mrcs := []promcfg.RelabelConfig{mrc}
+		e.Jobs, err = p.insertSilence(mrcs, e.Jobs, pc)
+		if err != nil {
+			log.Printf("Couldn't drop exploding label names on metric %s: %s\n", e.MetricName, err)
+			continue
+		}
+
+		err = config.StoreLabelDropRelabelConfigBombSquad(e, mrcs[0], bc)
+		if err != nil {
+			log.Printf("Couldn't store labeldrop relabel config for metric %s: %s\n", e.MetricName, err)
+			continue
+		}
+	}
+
+	explodingMetricNames, err := p.findExplodingMetricNames()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range explodingMetricNames {
+		mrc, err := config.GenerateMetricNameDropRelabelConfig(e)
 		if err != nil {
-			log.Printf("Error marshalling Prometheus config: %s\n", err)
+			log.Printf("Couldn't generate drop relabel config for metric names %s: %s\n", e.Pattern, err)
 			continue
 		}
 
-		err = p.PromConfigurator.Write(newPromConfigBytes)
+		mrcs := []promcfg.RelabelConfig{mrc}
+		e.Jobs, err = p.insertSilence(mrcs, e.Jobs, pc)
 		if err != nil {
-			log.Printf("Error writing Prometheus config: %s\n", err)
+			log.Printf("Couldn't drop exploding metric names %s: %s\n", e.Pattern, err)
 			continue
 		}
 
-		err = config.StoreMetricRelabelConfigBombSquad(s, mrc, p.BSConfigurator)
+		err = config.StoreMetricNameDropRelabelConfigBombSquad(e, mrcs[0], bc)
 		if err != nil {
-			log.Printf("Couldn't store metric relabel config for metric %s: %s\n", s.MetricName, err)
+			log.Printf("Couldn't store drop relabel config for metric names %s: %s\n", e.Pattern, err)
 			continue
 		}
 	}
@@ -102,6 +140,103 @@ func (p *Patrol) getTopCardinalities() error {
 	return nil
 }
 
+// silenceSeries inserts the relabel configs the suppressor generates for an
+// exploding series, replacing any earlier silence on the same metric and
+// label, and records the silence in the Bomb Squad config
+func (p *Patrol) silenceSeries(s config.HighCardSeries, suppressor config.Suppressor, pc, bc config.Configurator) error {
+	replaced, err := config.MergeSilenceScope(&s, bc)
+	if err != nil {
+		return fmt.Errorf("Couldn't merge scope of existing silence: %s", err)
+	}
+
+	mrcs, err := suppressor.RelabelConfigs(s)
+	if err != nil {
+		return fmt.Errorf("Couldn't generate metric relabel config: %s", err)
+	}
+
+	s.Jobs, err = p.insertSilence(mrcs, s.Jobs, pc)
+	if err != nil {
+		return err
+	}
+
+	if stale := staleRules(replaced, mrcs); len(stale) > 0 {
+		newPromConfig, err := config.RemoveMetricRelabelConfigFromPromConfig(stale, pc)
+		if err == nil {
+			err = config.WritePromConfig(newPromConfig, pc)
+		}
+		if err != nil {
+			log.Printf("Couldn't remove replaced silence for metric %s: %s\n", s.MetricName, err)
+		}
+	}
+
+	err = config.StoreMetricRelabelConfigBombSquad(s, suppressor, mrcs, bc)
+	if err != nil {
+		return fmt.Errorf("Couldn't store metric relabel config: %s", err)
+	}
+	return nil
+}
+
+// insertSilence adds relabel configs to the scrape configs of the passed
+// jobs, and writes the result back to the Prometheus config. It returns the
+// jobs the silence actually ended up in, where nil means all of them.
+func (p *Patrol) insertSilence(mrcs []promcfg.RelabelConfig, jobs []string, pc config.Configurator) ([]string, error) {
+	for i := range mrcs {
+		err := prom.ReUnmarshal(&mrcs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	newPromConfig, scopedJobs, err := config.InsertMetricRelabelConfigToPromConfig(mrcs, jobs, pc)
+	if err != nil {
+		return nil, fmt.Errorf("Error inserting relabel config: %s", err)
+	}
+
+	err = config.WritePromConfig(newPromConfig, pc)
+	if err != nil {
+		return nil, fmt.Errorf("Error writing Prometheus config: %s", err)
+	}
+
+	return scopedJobs, nil
+}
+
+// suppressorFor returns the Suppressor the Bomb Squad config asks for
+func (p *Patrol) suppressorFor(metricName string, bc config.Configurator) (config.Suppressor, error) {
+	b, err := config.ReadBombSquadConfig(bc)
+	if err != nil {
+		return nil, err
+	}
+	return b.SuppressorFor(metricName)
+}
+
+// staleRules returns the rules of a replaced silence that its replacement no
+// longer uses
+func staleRules(replaced []promcfg.RelabelConfig, mrcs []promcfg.RelabelConfig) []promcfg.RelabelConfig {
+	current := config.Silence{Rules: mrcs}
+	stale := []promcfg.RelabelConfig{}
+	for _, rule := range replaced {
+		if !current.UsesRule(rule) {
+			stale = append(stale, rule)
+		}
+	}
+	return stale
+}
+
+// jobsInSeries returns the distinct values of the job label across the
+// passed series
+func jobsInSeries(series []map[string]string) []string {
+	seen := map[string]bool{}
+	jobs := []string{}
+	for _, s := range series {
+		if job, ok := s["job"]; ok && !seen[job] {
+			seen[job] = true
+			jobs = append(jobs, job)
+		}
+	}
+	sort.Strings(jobs)
+	return jobs
+}
+
 func (p *Patrol) cardinalityTooHigh(iq *prom.InstantQuery) []string {
 	out := []string{}
 	for _, v := range iq.Data.Result {
@@ -131,33 +266,81 @@ func (p *Patrol) getDistinctLabelValuesInSeries(s map[string]string, tracker lab
 	}
 }
 
-func (p *Patrol) findHighCardSeries(metrics []string) []config.HighCardSeries {
-	hwmLabel := ""
-	var (
-		s      prom.Series
-		hwm, l int
-	)
-	res := []config.HighCardSeries{}
+// valueCounts returns how many of the passed series carry each value of the label
+func valueCounts(series []map[string]string, label string) map[string]int {
+	counts := map[string]int{}
+	for _, s := range series {
+		if v, ok := s[label]; ok {
+			counts[v]++
+		}
+	}
+	return counts
+}
 
-	for _, metricName := range metrics {
+// fetchSeries returns all series Prometheus knows of for the metric
+func (p *Patrol) fetchSeries(metricName string) (prom.Series, error) {
+	s := prom.Series{}
 
-		relativeURL, err := url.Parse("/api/v1/series")
-		query := p.PromURL.Query()
-		query.Set("match[]", fmt.Sprint(metricName))
-		relativeURL.RawQuery = query.Encode()
+	relativeURL, err := url.Parse("/api/v1/series")
+	if err != nil {
+		return s, fmt.Errorf("failed to parse relative api v1 series path: %s", err)
+	}
+	query := p.PromURL.Query()
+	query.Set("match[]", fmt.Sprint(metricName))
+	relativeURL.RawQuery = query.Encode()
 
-		queryURL := p.PromURL.ResolveReference(relativeURL)
+	queryURL := p.PromURL.ResolveReference(relativeURL)
+
+	err = p.fetchJSON(queryURL.String(), &s)
+	return s, err
+}
+
+// observeTopMetrics keeps the label name history of the metrics in the
+// topk that aren't exploding up to date
+func (p *Patrol) observeTopMetrics(iq *prom.InstantQuery, exploding []string) error {
+	if p.LabelNameGrowthThreshold <= 0 {
+		return nil
+	}
+
+	skip := map[string]bool{}
+	for _, m := range exploding {
+		skip[m] = true
+	}
+	for _, v := range iq.Data.Result {
+		metricName := v.Metric["metric_name"]
+		if metricName == "" || skip[metricName] {
+			continue
+		}
+		skip[metricName] = true
 
-		b, err := prom.Fetch(queryURL.String(), p.HTTPClient)
+		s, err := p.fetchSeries(metricName)
 		if err != nil {
-			// Bail here because we're cooked if we can't reach Prometheus
-			log.Fatal(err)
+			return err
 		}
+		p.observeLabelNames(metricName, s.Data)
+	}
+	return nil
+}
 
-		err = json.Unmarshal(b, &s)
+func (p *Patrol) findHighCardSeries(metrics []string) ([]config.HighCardSeries, []config.ExplodingLabelNames, error) {
+	hwmLabel := ""
+	var hwm, l int
+	res := []config.HighCardSeries{}
+	explodingNames := []config.ExplodingLabelNames{}
+
+	for _, metricName := range metrics {
+		s, err := p.fetchSeries(metricName)
 		if err != nil {
-			// Bail here because we're cooked if we can't wrangle Prometheus results
-			log.Fatal(err)
+			return nil, nil, err
+		}
+
+		// A metric sprouting new label names multiplies its series without any
+		// single label's values exploding, so silencing the highest-cardinality
+		// label would only hit an innocent bystander
+		if e, ok := p.findExplodingLabelNames(metricName, s.Data); ok {
+			e.Jobs = jobsInSeries(s.Data)
+			explodingNames = append(explodingNames, e)
+			continue
 		}
 
 		tracker := labelTracker{}
@@ -181,11 +364,14 @@ func (p *Patrol) findHighCardSeries(metrics []string) []config.HighCardSeries {
 			config.HighCardSeries{
 				MetricName:        metricName,
 				HighCardLabelName: model.LabelName(hwmLabel),
+				Jobs:              jobsInSeries(s.Data),
+				Scope:             scopeExplodingLabel(s.Data, hwmLabel, p.ScopeLabels),
+				ValueCounts:       valueCounts(s.Data, hwmLabel),
 			},
 		)
 		fmt.Printf("Detected exploding label \"%s\" on metric \"%s\"\n", hwmLabel, metricName)
 		ExplodingLabelGauge.WithLabelValues(metricName, hwmLabel).Set(float64(hwm))
 	}
 
-	return res
+	return res, explodingNames, nil
 }
@@ -0,0 +1,297 @@
+package patrol
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/prometheus/common/model"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// escalationLadder is the order in which ever stronger actions are taken
+// against a metric that keeps exploding
+var escalationLadder = []string{
+	config.EscalationSilence,
+	config.EscalationNextLabel,
+	config.EscalationDropMetric,
+	config.EscalationSampleLimit,
+}
+
+// cardCount returns how many series the card_count recording rule currently
+// counts for the metric
+func (p *Patrol) cardCount(metricName string) (float64, error) {
+	relativeURL, err := url.Parse("/api/v1/query")
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse relative api v1 query path: %s", err)
+	}
+
+	query := p.PromURL.Query()
+	query.Set("query", fmt.Sprintf("card_count{metric_name=\"%s\"}", metricName))
+	relativeURL.RawQuery = query.Encode()
+
+	queryURL := p.PromURL.ResolveReference(relativeURL)
+
+	iq := &prom.InstantQuery{}
+	err = p.fetchJSON(queryURL.String(), iq)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch card_count from prometheus: %s", err)
+	}
+
+	if len(iq.Data.Result) == 0 || len(iq.Data.Result[0].Value) < 2 {
+		return 0, nil
+	}
+	val, ok := iq.Data.Result[0].Value[1].(string)
+	if !ok {
+		return 0, fmt.Errorf("unexpected card_count value %v", iq.Data.Result[0].Value[1])
+	}
+	return strconv.ParseFloat(val, 64)
+}
+
+// hasOpenIncident reports whether the metric's explosion is already being
+// handled by the escalation ladder
+func (p *Patrol) hasOpenIncident(metricName string, bc config.Configurator) bool {
+	if p.EscalationGracePeriod <= 0 {
+		return false
+	}
+	b, err := config.ReadBombSquadConfig(bc)
+	if err != nil {
+		return false
+	}
+	return b.Incidents[metricName].Status == config.IncidentOpen
+}
+
+// openIncident starts checking whether the silence of an exploding series
+// stops it, so that it can be escalated if it doesn't
+func (p *Patrol) openIncident(s config.HighCardSeries, bc config.Configurator) error {
+	if p.EscalationGracePeriod <= 0 {
+		return nil
+	}
+
+	count, err := p.cardCount(s.MetricName)
+	if err != nil {
+		return err
+	}
+
+	return config.RecordIncidentStep(s.MetricName, config.IncidentStep{
+		Action:    config.EscalationSilence,
+		At:        time.Now(),
+		Silence:   fmt.Sprintf("%s.%s", s.MetricName, s.HighCardLabelName),
+		Jobs:      s.Jobs,
+		CardCount: count,
+	}, bc)
+}
+
+// verifySilences re-measures every metric with an open incident once its last
+// step has had EscalationGracePeriod to take effect. Metrics that have grown
+// by less than HighCardThreshold since are considered contained, and the rest
+// are escalated.
+func (p *Patrol) verifySilences(pc, bc config.Configurator) error {
+	if p.EscalationGracePeriod <= 0 {
+		return nil
+	}
+
+	b, err := config.ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+
+	metrics := []string{}
+	for metric := range b.Incidents {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	for _, metric := range metrics {
+		incident := b.Incidents[metric]
+		last := incident.LastStep()
+		if incident.Status != config.IncidentOpen || time.Since(last.At) < p.EscalationGracePeriod {
+			continue
+		}
+
+		count, err := p.cardCount(metric)
+		if err != nil {
+			log.Printf("Couldn't re-measure metric %s: %s\n", metric, err)
+			continue
+		}
+
+		if count-last.CardCount < p.HighCardThreshold {
+			fmt.Printf("Metric \"%s\" stopped exploding after %s\n", metric, last.Action)
+			err = config.SetIncidentStatus(metric, config.IncidentContained, bc)
+			if err != nil {
+				log.Printf("Couldn't update incident for metric %s: %s\n", metric, err)
+			}
+			continue
+		}
+
+		fmt.Printf("Metric \"%s\" grew by %.0f series despite %s, escalating\n", metric, count-last.CardCount, last.Action)
+		err = p.escalate(metric, incident, count, pc, bc)
+		if err != nil {
+			log.Printf("Couldn't escalate incident for metric %s: %s\n", metric, err)
+		}
+	}
+
+	return nil
+}
+
+// escalate takes the first action further up the ladder than the incident's
+// last step that applies to the metric, and records it
+func (p *Patrol) escalate(metricName string, incident config.Incident, count float64, pc, bc config.Configurator) error {
+	next := len(escalationLadder)
+	for i, action := range escalationLadder {
+		if action == incident.LastStep().Action {
+			next = i + 1
+		}
+	}
+
+	for _, action := range escalationLadder[next:] {
+		step := config.IncidentStep{
+			Action:    action,
+			At:        time.Now(),
+			Jobs:      incident.Steps[0].Jobs,
+			CardCount: count,
+		}
+
+		var (
+			ok  bool
+			err error
+		)
+		switch action {
+		case config.EscalationNextLabel:
+			ok, err = p.silenceNextLabel(metricName, incident, &step, pc, bc)
+		case config.EscalationDropMetric:
+			ok, err = p.dropMetric(metricName, &step, pc, bc)
+		case config.EscalationSampleLimit:
+			ok, err = p.limitSamples(&step, pc)
+		}
+		if err != nil {
+			return err
+		}
+		if !ok && step.Skipped != "" {
+			fmt.Printf("Skipped escalating incident for metric \"%s\" to %s: %s\n", metricName, action, step.Skipped)
+			err = config.RecordIncidentStep(metricName, step, bc)
+			if err != nil {
+				return err
+			}
+		}
+		if !ok {
+			continue
+		}
+
+		fmt.Printf("Escalated incident for metric \"%s\" to %s\n", metricName, action)
+		return config.RecordIncidentStep(metricName, step, bc)
+	}
+
+	fmt.Printf("Metric \"%s\" is still exploding, and there is nothing left to escalate to\n", metricName)
+	return config.SetIncidentStatus(metricName, config.IncidentExhausted, bc)
+}
+
+// silenceNextLabel silences the highest-cardinality label of the metric that
+// the incident hasn't silenced already
+func (p *Patrol) silenceNextLabel(metricName string, incident config.Incident, step *config.IncidentStep, pc, bc config.Configurator) (bool, error) {
+	s, err := p.fetchSeries(metricName)
+	if err != nil {
+		return false, err
+	}
+
+	skip := map[string]bool{"__name__": true, "job": true}
+	for _, label := range incident.SilencedLabels() {
+		skip[label] = true
+	}
+
+	tracker := labelTracker{}
+	for _, series := range s.Data {
+		p.getDistinctLabelValuesInSeries(series, tracker)
+	}
+
+	hwmLabel, hwm := "", 1
+	for label, values := range tracker {
+		if !skip[label] && (values.Cardinality() > hwm || values.Cardinality() == hwm && label < hwmLabel) {
+			hwm = values.Cardinality()
+			hwmLabel = label
+		}
+	}
+	if hwmLabel == "" {
+		return false, nil
+	}
+
+	hcs := config.HighCardSeries{
+		MetricName:        metricName,
+		HighCardLabelName: model.LabelName(hwmLabel),
+		Jobs:              jobsInSeries(s.Data),
+		Scope:             scopeExplodingLabel(s.Data, hwmLabel, p.ScopeLabels),
+		ValueCounts:       valueCounts(s.Data, hwmLabel),
+	}
+
+	suppressor, err := p.suppressorFor(metricName, bc)
+	if err != nil {
+		return false, err
+	}
+	err = p.silenceSeries(hcs, suppressor, pc, bc)
+	if err != nil {
+		return false, err
+	}
+
+	step.Silence = fmt.Sprintf("%s.%s", metricName, hwmLabel)
+	step.Jobs = hcs.Jobs
+	ExplodingLabelGauge.WithLabelValues(metricName, hwmLabel).Set(float64(hwm))
+	return true, nil
+}
+
+// dropMetric drops every series of the metric from the incident's jobs
+func (p *Patrol) dropMetric(metricName string, step *config.IncidentStep, pc, bc config.Configurator) (bool, error) {
+	e := config.ExplodingMetricNames{
+		Pattern: regexp.QuoteMeta(metricName),
+		Jobs:    step.Jobs,
+	}
+
+	mrc, err := config.GenerateMetricNameDropRelabelConfig(e)
+	if err != nil {
+		return false, err
+	}
+
+	mrcs := []promcfg.RelabelConfig{mrc}
+	e.Jobs, err = p.insertSilence(mrcs, e.Jobs, pc)
+	if err != nil {
+		return false, err
+	}
+
+	err = config.StoreMetricNameDropRelabelConfigBombSquad(e, mrcs[0], bc)
+	if err != nil {
+		return false, err
+	}
+
+	step.Silence = e.Pattern
+	step.Jobs = e.Jobs
+	return true, nil
+}
+
+// limitSamples sets EscalationSampleLimit on the incident's jobs, as a last
+// resort that fails their scrapes outright. It's skipped if the incident
+// doesn't know which jobs the metric comes from, rather than limiting them all.
+func (p *Patrol) limitSamples(step *config.IncidentStep, pc config.Configurator) (bool, error) {
+	if p.EscalationSampleLimit == 0 {
+		return false, nil
+	}
+	if len(step.Jobs) == 0 {
+		step.Skipped = "the jobs the metric comes from are unknown"
+		return false, nil
+	}
+
+	previous, err := config.SetSampleLimit(p.EscalationSampleLimit, step.Jobs, pc)
+	if err != nil {
+		return false, err
+	}
+	if len(previous) == 0 {
+		return false, nil
+	}
+
+	step.PreviousSampleLimits = previous
+	return true, nil
+}
@@ -0,0 +1,165 @@
+package patrol
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+func newEscalationPatrol(t *testing.T, cardCount *float64) (*Patrol, func()) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/query" {
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"metric_name":"foo"},"value":[0,"%f"]}]}}`, *cardCount)
+			return
+		}
+
+		series := []string{}
+		for i := 0; i < 10; i++ {
+			series = append(series, fmt.Sprintf(`{"__name__":"foo","job":"prometheus","route":"bs_silence","user":"%d"}`, i))
+		}
+		w.Write([]byte(`{"status":"success","data":[` + strings.Join(series, ",") + `]}`))
+	}))
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	return &Patrol{
+		PromURL:               promurl,
+		HighCardThreshold:     100,
+		EscalationGracePeriod: time.Minute,
+		EscalationSampleLimit: 5000,
+		HTTPClient:            client,
+		PromConfigurator:      bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		BSConfigurator:        bstesting.NewMemConfigurator(t, []byte{}),
+	}, s.Close
+}
+
+// expireLastStep pretends the grace period of the incident's last step is up
+func expireLastStep(t *testing.T, c config.Configurator, metricName string) {
+	b, err := config.ReadBombSquadConfig(c)
+	require.NoError(t, err)
+	incident := b.Incidents[metricName]
+	incident.Steps[len(incident.Steps)-1].At = time.Now().Add(-time.Hour)
+	b.Incidents[metricName] = incident
+	require.NoError(t, config.WriteBombSquadConfig(b, c))
+}
+
+func TestEscalationLadder(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "route", Jobs: []string{"prometheus"}}
+	require.NoError(t, p.silenceSeries(hcs, config.ReplaceSuppressor{}, p.PromConfigurator, p.BSConfigurator))
+	require.NoError(t, p.openIncident(hcs, p.BSConfigurator))
+	require.True(t, p.hasOpenIncident("foo", p.BSConfigurator))
+
+	// Still within the grace period
+	cardCount = 2000
+	require.NoError(t, p.verifySilences(p.PromConfigurator, p.BSConfigurator))
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Len(t, b.Incidents["foo"].Steps, 1)
+
+	expected := []string{config.EscalationNextLabel, config.EscalationDropMetric, config.EscalationSampleLimit}
+	for i, action := range expected {
+		expireLastStep(t, p.BSConfigurator, "foo")
+		cardCount += 1000
+		require.NoError(t, p.verifySilences(p.PromConfigurator, p.BSConfigurator))
+
+		b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+		require.NoError(t, err)
+		require.Len(t, b.Incidents["foo"].Steps, i+2)
+		require.Equal(t, action, b.Incidents["foo"].LastStep().Action)
+		require.Equal(t, config.IncidentOpen, b.Incidents["foo"].Status)
+	}
+
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.user")
+	require.Equal(t, config.SilenceMetricNames, b.Silences["foo"].Kind)
+	require.Equal(t, []string{"route", "user"}, b.Incidents["foo"].SilencedLabels())
+
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, uint(5000), promConfig.ScrapeConfigs[0].SampleLimit)
+
+	// Nothing left to try
+	expireLastStep(t, p.BSConfigurator, "foo")
+	cardCount += 1000
+	require.NoError(t, p.verifySilences(p.PromConfigurator, p.BSConfigurator))
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, config.IncidentExhausted, b.Incidents["foo"].Status)
+	require.False(t, p.hasOpenIncident("foo", p.BSConfigurator))
+
+	require.NoError(t, config.ResolveIncident("foo", p.PromConfigurator, p.BSConfigurator))
+	promConfig, err = config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, uint(0), promConfig.ScrapeConfigs[0].SampleLimit)
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.NotContains(t, b.Incidents, "foo")
+}
+
+func TestEscalationContained(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "route", Jobs: []string{"prometheus"}}
+	require.NoError(t, p.silenceSeries(hcs, config.ReplaceSuppressor{}, p.PromConfigurator, p.BSConfigurator))
+	require.NoError(t, p.openIncident(hcs, p.BSConfigurator))
+
+	expireLastStep(t, p.BSConfigurator, "foo")
+	cardCount += 10
+	require.NoError(t, p.verifySilences(p.PromConfigurator, p.BSConfigurator))
+
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, config.IncidentContained, b.Incidents["foo"].Status)
+	require.Len(t, b.Incidents["foo"].Steps, 1)
+	require.False(t, p.hasOpenIncident("foo", p.BSConfigurator))
+}
+
+func TestEscalationSkipsSampleLimitWithoutJobs(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	// The silence fell back to every job, so the incident doesn't know which
+	// ones the metric comes from
+	for _, action := range []string{config.EscalationSilence, config.EscalationDropMetric} {
+		require.NoError(t, config.RecordIncidentStep("foo", config.IncidentStep{Action: action, At: time.Now(), CardCount: cardCount}, p.BSConfigurator))
+	}
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+
+	require.NoError(t, p.escalate("foo", b.Incidents["foo"], cardCount+1000, p.PromConfigurator, p.BSConfigurator))
+
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	for _, sc := range promConfig.ScrapeConfigs {
+		require.Equal(t, uint(0), sc.SampleLimit)
+	}
+
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	incident := b.Incidents["foo"]
+	require.Equal(t, config.IncidentExhausted, incident.Status)
+	require.Equal(t, config.EscalationSampleLimit, incident.LastStep().Action)
+	require.NotEmpty(t, incident.LastStep().Skipped)
+	require.Empty(t, incident.LastStep().PreviousSampleLimits)
+	require.Contains(t, incident.String(), "sample_limit (skipped)")
+}
@@ -0,0 +1,96 @@
+package patrol
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	PrometheusErrorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "prometheus_errors_total",
+			Help:      "Count failed queries to Prometheus, retries included, by whether Prometheus was unavailable or gave a bad response",
+		},
+		[]string{"kind"},
+	)
+	PatrolFailuresCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "patrol_failures_total",
+			Help:      "Count patrols that couldn't look for explosions because Prometheus queries failed",
+		},
+	)
+	PatrolsSkippedCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "patrols_skipped_total",
+			Help:      "Count patrols skipped while Prometheus was deemed unhealthy",
+		},
+	)
+	CircuitOpenGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "prometheus_circuit_open",
+			Help:      "1 while patrols of a target are skipped because its Prometheus kept failing",
+		},
+		[]string{"target"},
+	)
+)
+
+// fetchJSON queries Prometheus and unmarshals its answer into v, retrying
+// while Prometheus is unavailable. It gives up once the patrol under way is
+// cancelled.
+func (p *Patrol) fetchJSON(endpoint string, v interface{}) error {
+	ctx := p.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return p.Retry.Retry(ctx, func() error {
+		err := prom.FetchJSON(ctx, endpoint, p.HTTPClient, v)
+		switch err.(type) {
+		case nil:
+		case *prom.UnavailableError:
+			PrometheusErrorsCounter.WithLabelValues("unavailable").Inc()
+		case *prom.BadResponseError:
+			PrometheusErrorsCounter.WithLabelValues("bad_response").Inc()
+		}
+		return err
+	})
+}
+
+// circuitClosed reports whether to patrol now. Once BreakerThreshold patrols
+// in a row have failed, patrols are skipped for BreakerCooldown, after which
+// one is let through to see whether Prometheus is back.
+func (p *Patrol) circuitClosed() bool {
+	if p.BreakerThreshold <= 0 || time.Now().After(p.circuitOpenUntil) {
+		return true
+	}
+	PatrolsSkippedCounter.Inc()
+	return false
+}
+
+// recordPatrol keeps track of failed patrols, and opens the circuit when
+// there have been too many in a row
+func (p *Patrol) recordPatrol(err error) {
+	if err == nil {
+		if p.failedPatrols >= p.BreakerThreshold && p.BreakerThreshold > 0 {
+			log.Println("Prometheus is back, resuming patrols")
+		}
+		p.failedPatrols = 0
+		CircuitOpenGauge.WithLabelValues(p.Name).Set(0)
+		return
+	}
+
+	PatrolFailuresCounter.Inc()
+	p.failedPatrols++
+	if p.BreakerThreshold > 0 && p.failedPatrols >= p.BreakerThreshold {
+		p.circuitOpenUntil = time.Now().Add(p.BreakerCooldown)
+		CircuitOpenGauge.WithLabelValues(p.Name).Set(1)
+		log.Printf("%d patrols in a row failed, skipping patrols for %s\n", p.failedPatrols, p.BreakerCooldown)
+	}
+}
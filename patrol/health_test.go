@@ -0,0 +1,76 @@
+package patrol
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatrolSurvivesPrometheusRestart(t *testing.T) {
+	down := true
+	queries := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	p := &Patrol{
+		PromURL:           promurl,
+		HighCardThreshold: 100,
+		HTTPClient:        client,
+		Retry:             prom.Backoff{Attempts: 2, Initial: time.Millisecond, Max: time.Millisecond},
+		BreakerThreshold:  2,
+		BreakerCooldown:   time.Hour,
+		PromConfigurator:  bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		BSConfigurator:    bstesting.NewMemConfigurator(t, []byte{}),
+	}
+	failures := counterValue(t, PatrolFailuresCounter)
+	skipped := counterValue(t, PatrolsSkippedCounter)
+
+	// Each patrol retries once, and gives up without writing anything
+	p.patrol(context.Background())
+	require.Equal(t, 2, queries)
+	p.patrol(context.Background())
+	require.Equal(t, 4, queries)
+	require.Equal(t, failures+2, counterValue(t, PatrolFailuresCounter))
+	require.Equal(t, 0, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+
+	// Then patrols are skipped without asking Prometheus
+	p.patrol(context.Background())
+	require.Equal(t, 4, queries)
+	require.Equal(t, skipped+1, counterValue(t, PatrolsSkippedCounter))
+
+	// Until the cooldown is up
+	down = false
+	p.circuitOpenUntil = time.Now()
+	p.patrol(context.Background())
+	require.True(t, queries > 4)
+	require.Equal(t, 0, p.failedPatrols)
+}
+
+func counterValue(t *testing.T, c interface {
+	Write(*dto.Metric) error
+}) float64 {
+	m := &dto.Metric{}
+	require.NoError(t, c.Write(m))
+	return m.GetCounter().GetValue()
+}
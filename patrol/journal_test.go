@@ -0,0 +1,91 @@
+package patrol
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+// failingConfigurator refuses every write
+type failingConfigurator struct {
+	config.Configurator
+}
+
+func (failingConfigurator) Write([]byte) error {
+	return errors.New("reload failed")
+}
+
+func TestPatrolWritesEachConfigOnce(t *testing.T) {
+	promConfigurator := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bsConfigurator := bstesting.NewMemConfigurator(t, []byte{})
+	p := &Patrol{PromConfigurator: promConfigurator, BSConfigurator: bsConfigurator}
+
+	require.NoError(t, p.Journaled("test", func(pc, bc config.Configurator) error {
+		for _, label := range []string{"bar", "baz"} {
+			hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: model.LabelName(label), Jobs: []string{"prometheus"}}
+			require.NoError(t, p.silenceSeries(hcs, config.ReplaceSuppressor{}, pc, bc))
+		}
+		return nil
+	}))
+	require.Equal(t, 1, promConfigurator.Writes)
+	require.Equal(t, 1, bsConfigurator.Writes)
+	require.Equal(t, promConfigurator, p.PromConfigurator)
+
+	promConfig, err := config.ReadPromConfig(promConfigurator)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 2)
+	b, err := config.ReadBombSquadConfig(bsConfigurator)
+	require.NoError(t, err)
+	require.Len(t, b.Silences, 2)
+}
+
+func TestPatrolDoesNotRecordSilencesThatFailedToApply(t *testing.T) {
+	bsConfigurator := bstesting.NewMemConfigurator(t, []byte{})
+	p := &Patrol{
+		PromConfigurator: failingConfigurator{bstesting.NewMemConfigurator(t, bstesting.PromConfig())},
+		BSConfigurator:   bsConfigurator,
+	}
+
+	err := p.Journaled("test", func(pc, bc config.Configurator) error {
+		hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+		return p.silenceSeries(hcs, config.ReplaceSuppressor{}, pc, bc)
+	})
+	require.Error(t, err)
+	require.Equal(t, 0, bsConfigurator.Writes)
+}
+
+func TestPatrolRecoversJournalAfterGracePeriod(t *testing.T) {
+	promConfigurator := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	bsConfigurator := bstesting.NewMemConfigurator(t, []byte{})
+	p := &Patrol{
+		PromConfigurator:   promConfigurator,
+		BSConfigurator:     failingConfigurator{bsConfigurator},
+		Journal:            bstesting.NewMemConfigurator(t, []byte{}),
+		JournalGracePeriod: time.Hour,
+	}
+
+	// The Prometheus config went through, the Bomb Squad config didn't
+	err := p.Journaled("test", func(pc, bc config.Configurator) error {
+		hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}}
+		return p.silenceSeries(hcs, config.ReplaceSuppressor{}, pc, bc)
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, promConfigurator.Writes)
+
+	// Still within the grace period, ex. a slow reload
+	p.BSConfigurator = bsConfigurator
+	p.recoverJournal()
+	require.Equal(t, 0, bsConfigurator.Writes)
+
+	p.JournalGracePeriod = time.Nanosecond
+	p.recoverJournal()
+	require.Equal(t, 1, bsConfigurator.Writes)
+	b, err := config.ReadBombSquadConfig(bsConfigurator)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.bar")
+}
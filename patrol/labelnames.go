@@ -0,0 +1,136 @@
+package patrol
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/deckarep/golang-set"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	ExplodingLabelNamesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "exploding_label_names",
+			Help:      "Track which metrics have been identified as having an exploding set of label names",
+		},
+		[]string{"metric_name", "label_pattern"},
+	)
+)
+
+// labelNameSet returns the distinct label names present across all passed series
+func labelNameSet(series []map[string]string) mapset.Set {
+	names := mapset.NewSet()
+	for _, s := range series {
+		for label := range s {
+			names.Add(label)
+		}
+	}
+	return names
+}
+
+const (
+	// labelNameWarmup is how many patrols a metric's label names are
+	// collected over before new ones count towards an explosion, so that
+	// labels only some series carry make it into the baseline
+	labelNameWarmup = 3
+	// labelNameHistoryTTL is how long a metric can go unobserved before its
+	// label names are collected afresh, rather than compared against a
+	// baseline that may have gone stale meanwhile
+	labelNameHistoryTTL = 10 * time.Minute
+)
+
+// labelNameHistory is the label names seen on a metric's series
+type labelNameHistory struct {
+	known    mapset.Set
+	patrols  int
+	lastSeen time.Time
+}
+
+// labelNamesOf returns the label name history of a metric, started afresh if
+// the metric hasn't been observed for a while
+func (p *Patrol) labelNamesOf(metricName string) *labelNameHistory {
+	if p.labelNameHistory == nil {
+		p.labelNameHistory = map[string]*labelNameHistory{}
+	}
+
+	h, ok := p.labelNameHistory[metricName]
+	if !ok || time.Since(h.lastSeen) > labelNameHistoryTTL {
+		h = &labelNameHistory{known: mapset.NewSet()}
+		p.labelNameHistory[metricName] = h
+	}
+	h.lastSeen = time.Now()
+	h.patrols++
+	return h
+}
+
+// observeLabelNames adds the label names on a metric's series to its history,
+// so that its baseline is current should it start exploding
+func (p *Patrol) observeLabelNames(metricName string, series []map[string]string) {
+	h := p.labelNamesOf(metricName)
+	h.known = h.known.Union(labelNameSet(series))
+}
+
+// findExplodingLabelNames compares the label names on a metric's series against
+// all those seen on previous patrols, and reports the metric if there are at
+// least LabelNameGrowthThreshold new ones. Label names only become part of the
+// history once they're not part of an explosion.
+func (p *Patrol) findExplodingLabelNames(metricName string, series []map[string]string) (config.ExplodingLabelNames, bool) {
+	h := p.labelNamesOf(metricName)
+	current := labelNameSet(series)
+	if h.patrols <= labelNameWarmup || p.LabelNameGrowthThreshold <= 0 {
+		h.known = h.known.Union(current)
+		return config.ExplodingLabelNames{}, false
+	}
+
+	e, ok := p.explodingLabelNames(metricName, h.known, current)
+	if !ok {
+		h.known = h.known.Union(current)
+	}
+	return e, ok
+}
+
+// explodingLabelNames reports the label names current has over baseline, if
+// there are enough of them and they can be told apart by a pattern
+func (p *Patrol) explodingLabelNames(metricName string, baseline, current mapset.Set) (config.ExplodingLabelNames, bool) {
+	added := current.Difference(baseline)
+	if added.Cardinality() < p.LabelNameGrowthThreshold {
+		return config.ExplodingLabelNames{}, false
+	}
+
+	names := []string{}
+	for _, n := range added.ToSlice() {
+		names = append(names, n.(string))
+	}
+	sort.Strings(names)
+
+	pattern := inferPattern(names)
+	if pattern == "" {
+		fmt.Printf("Label names on metric \"%s\" are exploding, but share no common prefix or suffix to suppress\n", metricName)
+		return config.ExplodingLabelNames{}, false
+	}
+
+	// Never drop a label that was there before the explosion started, or any
+	// of the labels Prometheus itself relies on
+	re := regexp.MustCompile("^(?:" + pattern + ")$")
+	for _, n := range baseline.Union(mapset.NewSet("__name__", "job", "instance")).ToSlice() {
+		if re.MatchString(n.(string)) {
+			fmt.Printf("Label names on metric \"%s\" are exploding, but pattern \"%s\" would also drop label \"%s\"\n", metricName, pattern, n)
+			return config.ExplodingLabelNames{}, false
+		}
+	}
+
+	fmt.Printf("Detected exploding label names \"%s\" on metric \"%s\"\n", pattern, metricName)
+	ExplodingLabelNamesGauge.WithLabelValues(metricName, pattern).Set(float64(added.Cardinality()))
+
+	return config.ExplodingLabelNames{
+		MetricName: metricName,
+		Pattern:    pattern,
+		Count:      added.Cardinality(),
+		Names:      names,
+	}, true
+}
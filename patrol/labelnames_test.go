@@ -0,0 +1,77 @@
+package patrol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInferPattern(t *testing.T) {
+	require.Equal(t, "tag_.*", inferPattern([]string{"tag_acme", "tag_globex", "tag_initech"}))
+	require.Equal(t, "http_req_.*_seconds", inferPattern([]string{"http_req_1f2e_seconds", "http_req_9a8b_seconds"}))
+	require.Equal(t, "only_one", inferPattern([]string{"only_one"}))
+	require.Equal(t, "", inferPattern([]string{"foo", "bar"}))
+	require.Equal(t, "", inferPattern([]string{}))
+}
+
+func TestFindExplodingLabelNames(t *testing.T) {
+	p := &Patrol{LabelNameGrowthThreshold: 3}
+
+	series := []map[string]string{
+		{"__name__": "foo", "job": "app", "instance": "a"},
+		{"__name__": "foo", "job": "app", "instance": "b"},
+	}
+	for i := 0; i < labelNameWarmup; i++ {
+		_, ok := p.findExplodingLabelNames("foo", series)
+		require.False(t, ok)
+	}
+
+	series = append(series,
+		map[string]string{"__name__": "foo", "job": "app", "instance": "a", "tag_acme": "1"},
+		map[string]string{"__name__": "foo", "job": "app", "instance": "a", "tag_globex": "1"},
+		map[string]string{"__name__": "foo", "job": "app", "instance": "a", "tag_initech": "1"},
+	)
+	e, ok := p.findExplodingLabelNames("foo", series)
+	require.True(t, ok)
+	require.Equal(t, "foo", e.MetricName)
+	require.Equal(t, "tag_.*", e.Pattern)
+	require.Equal(t, 3, e.Count)
+}
+
+func TestOptionalLabelNamesAreNotExploding(t *testing.T) {
+	p := &Patrol{LabelNameGrowthThreshold: 1}
+
+	// Only some series carry the optional labels, and not always the same
+	// ones
+	p.findExplodingLabelNames("foo", []map[string]string{
+		{"__name__": "foo", "job": "app"},
+		{"__name__": "foo", "job": "app", "le": "1"},
+	})
+	p.findExplodingLabelNames("foo", []map[string]string{
+		{"__name__": "foo", "job": "app", "quantile": "0.5"},
+	})
+	p.findExplodingLabelNames("foo", []map[string]string{
+		{"__name__": "foo", "job": "app"},
+	})
+
+	_, ok := p.findExplodingLabelNames("foo", []map[string]string{
+		{"__name__": "foo", "job": "app", "le": "1"},
+		{"__name__": "foo", "job": "app", "quantile": "0.5"},
+	})
+	require.False(t, ok)
+}
+
+func TestLabelNameHistoryOfUnobservedMetricsExpires(t *testing.T) {
+	p := &Patrol{LabelNameGrowthThreshold: 1}
+	series := []map[string]string{{"__name__": "foo", "job": "app"}}
+	for i := 0; i <= labelNameWarmup; i++ {
+		p.observeLabelNames("foo", series)
+	}
+
+	p.labelNameHistory["foo"].lastSeen = time.Now().Add(-2 * labelNameHistoryTTL)
+
+	// Back after a while, with a label added meanwhile
+	_, ok := p.findExplodingLabelNames("foo", []map[string]string{{"__name__": "foo", "job": "app", "version": "2"}})
+	require.False(t, ok)
+}
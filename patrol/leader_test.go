@@ -0,0 +1,66 @@
+package patrol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnlyTheLeaderChangesConfigs(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	leader := false
+	p.IsLeader = func() bool { return leader }
+
+	p.patrol(context.Background())
+	require.Equal(t, 0, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, 0, p.BSConfigurator.(*bstesting.MemConfigurator).Writes)
+
+	leader = true
+	p.patrol(context.Background())
+	require.Equal(t, 1, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, 1, p.BSConfigurator.(*bstesting.MemConfigurator).Writes)
+}
+
+func TestOnlyTheLeaderBootstrapsAndRecovers(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	// A change left behind by the last replica to lead
+	journal := bstesting.NewMemConfigurator(t, []byte(`entries:
+- id: abandoned
+  operation: silence foo.bar
+  started: 2019-01-01T00:00:00Z
+  bs_config: "Version: 2"
+`))
+	bootstraps := 0
+	leader := false
+	p.Interval = 10 * time.Millisecond
+	p.Journal = journal
+	p.IsLeader = func() bool { return leader }
+	p.Bootstrap = func() error {
+		bootstraps++
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	p.Run(ctx)
+	cancel()
+	require.Equal(t, 0, bootstraps)
+	require.Equal(t, 0, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, 0, p.BSConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, 0, journal.Writes)
+
+	leader = true
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	p.Run(ctx)
+	cancel()
+	require.Equal(t, 1, bootstraps)
+	require.NotContains(t, string(journal.Data), "abandoned")
+}
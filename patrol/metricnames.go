@@ -0,0 +1,198 @@
+package patrol
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/deckarep/golang-set"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+)
+
+var (
+	ExplodingMetricNamesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "exploding_metric_names",
+			Help:      "Track which metric name patterns have been identified as exploding",
+		},
+		[]string{"metric_pattern"},
+	)
+)
+
+// metricNameHistory tracks the metric names seen within one job (or across all
+// of them). previous is what we saw last patrol, while baseline is the last set
+// seen while things were quiet, and is what we refuse to ever drop.
+type metricNameHistory struct {
+	previous mapset.Set
+	baseline mapset.Set
+}
+
+// getMetricNamesByJob returns the distinct metric names currently exposed by
+// each job. It lists them from Prometheus's label index, one job at a time,
+// rather than querying every series.
+func (p *Patrol) getMetricNamesByJob() (map[string]mapset.Set, error) {
+	jobs, err := p.getLabelValues("job", "")
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string]mapset.Set{}
+	for _, job := range jobs {
+		jobNames, err := p.getLabelValues(string(model.MetricNameLabel), fmt.Sprintf("{job=%q}", job))
+		if err != nil {
+			return nil, err
+		}
+		names[job] = mapset.NewSet()
+		for _, n := range jobNames {
+			names[job].Add(n)
+		}
+	}
+	return names, nil
+}
+
+// getLabelValues returns the values of a label, on just the series matching
+// match if it's given
+func (p *Patrol) getLabelValues(labelName, match string) ([]string, error) {
+	relativeURL, err := url.Parse("/api/v1/label/" + url.PathEscape(labelName) + "/values")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse relative api v1 label values path: %s", err)
+	}
+
+	if match != "" {
+		query := p.PromURL.Query()
+		query.Set("match[]", match)
+		relativeURL.RawQuery = query.Encode()
+	}
+
+	queryURL := p.PromURL.ResolveReference(relativeURL)
+
+	lv := &prom.LabelValues{}
+	err = p.fetchJSON(queryURL.String(), lv)
+	if err != nil {
+		return nil, err
+	}
+	return lv.Data, nil
+}
+
+// findExplodingMetricNames looks for a surge in distinct metric names, first
+// within each job and then across all of them, since a handful of jobs each
+// growing a little can add up to an explosion. Metric names are only looked
+// at once every MetricNameInterval.
+func (p *Patrol) findExplodingMetricNames() ([]config.ExplodingMetricNames, error) {
+	res := []config.ExplodingMetricNames{}
+	if p.MetricNameGrowthThreshold <= 0 {
+		return res, nil
+	}
+	if p.MetricNameInterval > 0 && time.Since(p.lastMetricNames) < p.MetricNameInterval {
+		return res, nil
+	}
+
+	names, err := p.getMetricNamesByJob()
+	if err != nil {
+		return res, err
+	}
+	p.lastMetricNames = time.Now()
+
+	if p.metricNameHistory == nil {
+		p.metricNameHistory = map[string]*metricNameHistory{}
+	}
+	if p.allMetricNames == nil {
+		p.allMetricNames = &metricNameHistory{}
+	}
+
+	jobs := []string{}
+	for job := range names {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+
+	found := map[string]bool{}
+	all := mapset.NewSet()
+	for _, job := range jobs {
+		all = all.Union(names[job])
+
+		h, ok := p.metricNameHistory[job]
+		if !ok {
+			h = &metricNameHistory{}
+			p.metricNameHistory[job] = h
+		}
+
+		if e, ok := p.metricNameGrowth(h, names[job]); ok {
+			e.Jobs = []string{job}
+			found[e.Pattern] = true
+			res = append(res, e)
+		}
+	}
+
+	if e, ok := p.metricNameGrowth(p.allMetricNames, all); ok && !found[e.Pattern] {
+		re := regexp.MustCompile("^(?:" + e.Pattern + ")$")
+		for _, job := range jobs {
+			for _, n := range names[job].ToSlice() {
+				if re.MatchString(n.(string)) {
+					e.Jobs = append(e.Jobs, job)
+					break
+				}
+			}
+		}
+		res = append(res, e)
+	}
+
+	for _, e := range res {
+		fmt.Printf("Detected %d exploding metric names matching \"%s\" (jobs: %v)\n", e.Count, e.Pattern, e.Jobs)
+		ExplodingMetricNamesGauge.WithLabelValues(e.Pattern).Set(float64(e.Count))
+	}
+
+	return res, nil
+}
+
+// metricNameGrowth records the current metric names, and reports a pattern
+// for the new ones if there are at least MetricNameGrowthThreshold of them
+func (p *Patrol) metricNameGrowth(h *metricNameHistory, current mapset.Set) (config.ExplodingMetricNames, bool) {
+	previous := h.previous
+	h.previous = current
+	if previous == nil {
+		// Our first look is the only baseline we have
+		h.baseline = current
+		return config.ExplodingMetricNames{}, false
+	}
+
+	added := current.Difference(previous)
+	if added.Cardinality() < p.MetricNameGrowthThreshold {
+		h.baseline = current
+		return config.ExplodingMetricNames{}, false
+	}
+
+	names := []string{}
+	for _, n := range added.ToSlice() {
+		names = append(names, n.(string))
+	}
+	sort.Strings(names)
+
+	pattern := inferPattern(names)
+	if pattern == "" {
+		fmt.Printf("%d new metric names appeared, but share no common prefix or suffix to suppress\n", len(names))
+		return config.ExplodingMetricNames{}, false
+	}
+
+	// Don't drop anything that was around before the explosion started, or
+	// the recording rule we rely on to spot explosions in the first place
+	re := regexp.MustCompile("^(?:" + pattern + ")$")
+	for _, n := range h.baseline.Union(mapset.NewSet("card_count")).ToSlice() {
+		if re.MatchString(n.(string)) {
+			fmt.Printf("Metric names matching \"%s\" are exploding, but the pattern would also drop metric \"%s\"\n", pattern, n)
+			return config.ExplodingMetricNames{}, false
+		}
+	}
+
+	return config.ExplodingMetricNames{
+		Pattern: pattern,
+		Count:   added.Cardinality(),
+		Names:   names,
+	}, true
+}
@@ -0,0 +1,82 @@
+package patrol
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindExplodingMetricNames(t *testing.T) {
+	names := []string{"http_requests_total", "http_req_duration_seconds"}
+
+	queries := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries++
+		lv := prom.LabelValues{Status: "success"}
+		switch r.URL.Path {
+		case "/api/v1/label/job/values":
+			lv.Data = []string{"app"}
+		case "/api/v1/label/__name__/values":
+			if r.URL.Query().Get("match[]") == `{job="app"}` {
+				lv.Data = names
+			}
+		}
+		json.NewEncoder(w).Encode(lv)
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	p := &Patrol{
+		PromURL:                   promurl,
+		HTTPClient:                client,
+		MetricNameGrowthThreshold: 3,
+	}
+
+	res, err := p.findExplodingMetricNames()
+	require.NoError(t, err)
+	require.Empty(t, res)
+
+	names = append(names, "http_req_1f2e_seconds", "http_req_9a8b_seconds", "http_req_77cd_seconds")
+	res, err = p.findExplodingMetricNames()
+	require.NoError(t, err)
+	require.Empty(t, res, "pattern would drop http_req_duration_seconds, which predates the explosion")
+
+	names = []string{"http_requests_total", "app_a1_total", "app_b2_total", "app_c3_total"}
+	p = &Patrol{
+		PromURL:                   promurl,
+		HTTPClient:                client,
+		MetricNameGrowthThreshold: 3,
+	}
+	saved := names
+	names = names[:1]
+	_, err = p.findExplodingMetricNames()
+	require.NoError(t, err)
+
+	names = saved
+	res, err = p.findExplodingMetricNames()
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "app_.*_total", res[0].Pattern)
+	require.Equal(t, []string{"app"}, res[0].Jobs)
+	require.Equal(t, 3, res[0].Count)
+
+	// Between looks, metric names aren't queried at all
+	p.MetricNameInterval = time.Hour
+	queries = 0
+	names = append(names, "app_d4_total", "app_e5_total", "app_f6_total")
+	res, err = p.findExplodingMetricNames()
+	require.NoError(t, err)
+	require.Empty(t, res)
+	require.Equal(t, 0, queries)
+}
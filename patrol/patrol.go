@@ -1,10 +1,13 @@
 package patrol
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/Fresh-Tracks/bomb-squad/config"
@@ -15,23 +18,280 @@ var (
 	iq prom.InstantQuery
 )
 
+// errObserveOnly throws away the changes of a patrol by a replica that isn't
+// the leader
+var errObserveOnly = errors.New("not the leader, only observing")
+
+// errConfigsChanged throws away changes worked out from configs that someone
+// else, ex. a peer propagating a silence, wrote in the meantime
+var errConfigsChanged = errors.New("configs changed while working out changes to them")
+
+// DefaultJournalGracePeriod is how long a change can sit in the journal
+// before the patrol takes it as abandoned, unless JournalGracePeriod says
+// otherwise
+const DefaultJournalGracePeriod = time.Minute
+
 type Patrol struct {
 	PromURL           *url.URL
 	Interval          time.Duration
 	HighCardN         int
 	HighCardThreshold float64
+	// LabelNameGrowthThreshold is how many new label names a metric must sprout
+	// between patrols to be considered exploding. Zero disables the check.
+	LabelNameGrowthThreshold int
+	// MetricNameGrowthThreshold is how many new metric names must appear
+	// between patrols to be considered exploding. Zero disables the check.
+	MetricNameGrowthThreshold int
+	// MetricNameInterval is how often metric names are checked for growth,
+	// which takes a query per job. Zero checks them every patrol.
+	MetricNameInterval time.Duration
+	// ScopeLabels are labels such as namespace, instance or pod used to limit
+	// a silence to just the targets responsible for an exploding label
+	ScopeLabels []string
+	// EscalationGracePeriod is how long a silence gets to stop an explosion
+	// before stronger action is taken. Zero disables the check.
+	EscalationGracePeriod time.Duration
+	// EscalationSampleLimit is the sample_limit set on a metric's jobs when
+	// nothing else stopped it exploding. Zero skips that step.
+	EscalationSampleLimit uint
+	// ReconcileInterval is how often the silences in the Bomb Squad config are
+	// checked against the Prometheus config, and put back where they went
+	// missing. Zero disables reconciliation.
+	ReconcileInterval time.Duration
 	HTTPClient        *http.Client
-	PromConfigurator  config.Configurator
-	BSConfigurator    config.Configurator
+	// Retry is how queries are retried while Prometheus is unavailable
+	Retry prom.Backoff
+	// BreakerThreshold is how many patrols in a row can fail to query
+	// Prometheus before patrols are skipped for BreakerCooldown. Zero never
+	// skips a patrol.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	PromConfigurator config.Configurator
+	BSConfigurator   config.Configurator
+	// Journal, if set, records changes to both configs until both are
+	// written
+	Journal config.Configurator
+	// JournalGracePeriod is how long a change can sit in the Journal before
+	// the patrol takes it as abandoned. It must outlast a write of the
+	// Prometheus config, reload included. Zero means
+	// DefaultJournalGracePeriod.
+	JournalGracePeriod time.Duration
+	// IsLeader, if set, reports whether this replica may change the configs.
+	// The others only observe: they detect explosions, but throw away the
+	// changes they'd make.
+	IsLeader func() bool
+	// Bootstrap, if set, is run by the first patrol this replica leads, ex. to
+	// install Bomb Squad's recording rules. It's tried again by the next
+	// patrol if it fails.
+	Bootstrap func() error
+	// Name tells the patrols of several Prometheus targets apart in logs
+	Name string
+	// Peers are the patrols of the other Prometheus targets, ex. the other
+	// shards, that the silences this patrol puts in place are copied to
+	Peers []*Patrol
+	// WriteLock, if set, is held while writing this patrol's configs. Peers
+	// take it too when they copy silences over, so the two never write the
+	// same config at the same time.
+	WriteLock sync.Locker
+
+	labelNameHistory  map[string]*labelNameHistory
+	metricNameHistory map[string]*metricNameHistory
+	allMetricNames    *metricNameHistory
+	lastMetricNames   time.Time
+	lastReconcile     time.Time
+	failedPatrols     int
+	circuitOpenUntil  time.Time
+	reconcileCh       chan struct{}
+	reconcileOnce     sync.Once
+	bootstrapped      bool
+	// ctx is the context of the patrol under way, if any, which cuts its
+	// queries to Prometheus short
+	ctx context.Context
 }
 
-func (p *Patrol) Run() {
+// Run patrols every Interval until ctx is cancelled. A patrol under way when
+// it is cancelled either throws its changes away, if it hasn't started writing
+// them, or finishes writing them, so Run never returns with a change half
+// applied.
+func (p *Patrol) Run(ctx context.Context) {
 	ticker := time.NewTicker(p.Interval)
-	for range ticker.C {
-		err := p.getTopCardinalities()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.patrol(ctx)
+		case <-p.reconcileRequests():
+			if p.leading() {
+				p.reconcileNow()
+			}
+		}
+	}
+}
+
+// patrol looks for explosions and escalates the ones that carry on. Only the
+// leader keeps what it changes, and only if ctx wasn't cancelled meanwhile.
+func (p *Patrol) patrol(ctx context.Context) {
+	if !p.circuitClosed() {
+		return
+	}
+	p.ctx = ctx
+	defer func() {
+		p.ctx = nil
+	}()
+
+	leading := p.leading()
+	if leading {
+		p.lock()
+		p.bootstrap()
+		p.recoverJournal()
+		p.unlock()
+	}
+
+	propagate := leading && len(p.Peers) > 0
+	var before map[string]config.Silence
+	if propagate {
+		b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+		if err != nil {
+			log.Printf("Couldn't read silences to propagate: %s\n", err)
+			propagate = false
+		}
+		before = b.Silences
+	}
+
+	var detectErr error
+	err := p.Journaled("patrol", func(pc, bc config.Configurator) error {
+		detectErr = p.getTopCardinalities(pc, bc)
+		if detectErr != nil {
+			return detectErr
+		}
+
+		err := p.verifySilences(pc, bc)
 		if err != nil {
-			log.Fatalf("Couldn't retrieve top cardinalities: %s\n", err)
+			log.Printf("Couldn't verify silences: %s\n", err)
 		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !leading {
+			return errObserveOnly
+		}
+		return nil
+	})
+	if detectErr != nil && ctx.Err() != nil {
+		log.Println("Patrol interrupted while looking for explosions, throwing away its changes")
+		return
+	}
+	p.recordPatrol(detectErr)
+	if detectErr != nil {
+		log.Printf("Couldn't look for explosions, skipping this patrol: %s\n", detectErr)
+		return
+	}
+	if err != nil && err == ctx.Err() {
+		log.Println("Patrol interrupted before writing anything, throwing away its changes")
+		return
+	}
+	if err == errConfigsChanged {
+		log.Println("Configs were changed during this patrol, throwing away its changes for the next patrol to redo")
+		return
+	}
+	if err != nil && err != errObserveOnly {
+		log.Printf("Couldn't apply this patrol's changes: %s\n", err)
+	}
+	if err == nil && propagate {
+		p.propagateSilences(before)
+	}
+
+	if leading {
+		p.reconcileIfDue()
+	}
+}
+
+func (p *Patrol) lock() {
+	if p.WriteLock != nil {
+		p.WriteLock.Lock()
+	}
+}
+
+func (p *Patrol) unlock() {
+	if p.WriteLock != nil {
+		p.WriteLock.Unlock()
+	}
+}
+
+// leading reports whether this replica may change the configs
+func (p *Patrol) leading() bool {
+	return p.IsLeader == nil || p.IsLeader()
+}
+
+// Journaled runs f against the Prometheus and Bomb Squad configs with their
+// writes held back, then writes each config once, so that however many
+// metrics explode at once Prometheus is reloaded only once. The change is
+// recorded in the Journal, if there is one, until both configs are written
+// (see config.Journaled). f gets the configs to change as arguments, leaving
+// the Patrol untouched, so peers can run it from their own goroutines.
+//
+// WriteLock is only taken once f is done, so it isn't held while querying
+// Prometheus. If either config was written by someone else while f ran, the
+// change is thrown away with errConfigsChanged rather than overwrite theirs.
+func (p *Patrol) Journaled(operation string, f func(pc, bc config.Configurator) error) error {
+	locked := false
+	defer func() {
+		if locked {
+			p.unlock()
+		}
+	}()
+
+	return config.Journaled(operation, p.PromConfigurator, p.BSConfigurator, p.Journal, func(promBatch, bsBatch config.Configurator) error {
+		err := f(promBatch, bsBatch)
+		if err != nil {
+			return err
+		}
+
+		// Held until both configs are written
+		p.lock()
+		locked = true
+		for _, c := range []config.Configurator{promBatch, bsBatch} {
+			changed, err := c.(*config.BatchConfigurator).Changed()
+			if err != nil {
+				return err
+			}
+			if changed {
+				return errConfigsChanged
+			}
+		}
+		return nil
+	})
+}
+
+// bootstrap runs Bootstrap, unless it already went through
+func (p *Patrol) bootstrap() {
+	if p.Bootstrap == nil || p.bootstrapped {
+		return
+	}
+	err := p.Bootstrap()
+	if err != nil {
+		log.Printf("Couldn't bootstrap: %s\n", err)
+		return
+	}
+	p.bootstrapped = true
+}
+
+// recoverJournal sees through changes left in the Journal by patrols or
+// commands that didn't get to write both configs. Changes started within the
+// grace period may still be under way, ex. in a `bs` command run alongside.
+func (p *Patrol) recoverJournal() {
+	if p.Journal == nil {
+		return
+	}
+	grace := p.JournalGracePeriod
+	if grace == 0 {
+		grace = DefaultJournalGracePeriod
+	}
+	err := config.RecoverJournal(p.PromConfigurator, p.BSConfigurator, p.Journal, grace)
+	if err != nil {
+		log.Printf("Couldn't recover journal: %s\n", err)
 	}
 }
 
@@ -41,6 +301,14 @@ func MetricResetHandler() http.Handler {
 		labelName := req.URL.Query().Get("label")
 		fmt.Printf("Resetting metrics for %s.%s\n", metricName, labelName)
 
+		switch req.URL.Query().Get("type") {
+		case "label_names":
+			ExplodingLabelNamesGauge.WithLabelValues(metricName, labelName).Set(float64(0.))
+			return
+		case "metric_names":
+			ExplodingMetricNamesGauge.WithLabelValues(metricName).Set(float64(0.))
+			return
+		}
 		ExplodingLabelGauge.WithLabelValues(metricName, labelName).Set(float64(0.))
 	})
 }
@@ -1,6 +1,7 @@
 package patrol_test
 
 import (
+	"context"
 	"reflect"
 	"sync"
 	"testing"
@@ -39,12 +40,18 @@ func TestPatrol(t *testing.T) {
 		Interval:   100 * time.Millisecond,
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
 	wg.Add(1)
 	go func() {
-		p.Run()
+		p.Run(ctx)
+		close(stopped)
 	}()
 	wg.Wait()
 
+	cancel()
+	<-stopped
 }
 
 func Must(t *testing.T, err error) {
@@ -0,0 +1,58 @@
+package patrol
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// inferPattern builds a regex that matches all of the passed names by way of
+// their longest common prefix and suffix, ex. tag_foo and tag_bar yield
+// "tag_.*". An empty string is returned when the names share neither, since
+// ".*" would match everything and we have no business suppressing that.
+func inferPattern(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	sorted := make([]string, len(names))
+	copy(sorted, names)
+	sort.Strings(sorted)
+
+	prefix := commonPrefix(sorted)
+	suffix := commonSuffix(sorted, len(prefix))
+	if prefix == "" && suffix == "" {
+		return ""
+	}
+
+	if len(sorted) == 1 {
+		return regexp.QuoteMeta(sorted[0])
+	}
+
+	return regexp.QuoteMeta(prefix) + ".*" + regexp.QuoteMeta(suffix)
+}
+
+// commonPrefix returns the longest prefix shared by all of the passed names
+func commonPrefix(names []string) string {
+	prefix := names[0]
+	for _, n := range names[1:] {
+		for !strings.HasPrefix(n, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
+
+// commonSuffix returns the longest suffix shared by all of the passed names,
+// without overlapping the first skip bytes of any name (those already belong
+// to the common prefix)
+func commonSuffix(names []string, skip int) string {
+	suffix := names[0][skip:]
+	for _, n := range names[1:] {
+		rest := n[skip:]
+		for !strings.HasSuffix(rest, suffix) {
+			suffix = suffix[1:]
+		}
+	}
+	return suffix
+}
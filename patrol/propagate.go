@@ -0,0 +1,63 @@
+package patrol
+
+import (
+	"log"
+	"sort"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+// propagateSilences copies the silences this patrol put in place or changed,
+// going by the silences there were before, to its peers. That way a metric
+// that moves to another shard stays silenced. Silences that were themselves
+// copied from a peer aren't copied on.
+func (p *Patrol) propagateSilences(before map[string]config.Silence) {
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	if err != nil {
+		log.Printf("Couldn't read silences to propagate: %s\n", err)
+		return
+	}
+
+	for _, key := range sortedSilenceKeys(b) {
+		s := b.Silences[key]
+		if s.Origin == config.OriginPropagated {
+			continue
+		}
+		if old, ok := before[key]; ok && sameRules(old.Rules, s.Rules) {
+			continue
+		}
+		for _, peer := range p.Peers {
+			err := peer.Journaled("propagate "+key, func(pc, bc config.Configurator) error {
+				return config.AdoptSilence(s, pc, bc)
+			})
+			if err != nil {
+				log.Printf("Couldn't propagate silence %s to %s: %s\n", key, peer.Name, err)
+				continue
+			}
+			log.Printf("Propagated silence %s to %s\n", key, peer.Name)
+		}
+	}
+}
+
+// sameRules reports whether two silences use the same relabel configs
+func sameRules(a, b []promcfg.RelabelConfig) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	fingerprints := func(rules []promcfg.RelabelConfig) []string {
+		res := []string{}
+		for _, rule := range rules {
+			res = append(res, config.Fingerprint(rule))
+		}
+		sort.Strings(res)
+		return res
+	}
+	fa, fb := fingerprints(a), fingerprints(b)
+	for i := range fa {
+		if fa[i] != fb[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package patrol
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilencesPropagateToPeers(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	peerPromConfigurator := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	peerBSConfigurator := bstesting.NewMemConfigurator(t, []byte{})
+	peer := &Patrol{Name: "shard-1", PromConfigurator: peerPromConfigurator, BSConfigurator: peerBSConfigurator}
+
+	p.Name, p.Peers, p.WriteLock = "shard-0", []*Patrol{peer}, &sync.Mutex{}
+	peer.Peers, peer.WriteLock = []*Patrol{p}, &sync.Mutex{}
+
+	p.patrol(context.Background())
+
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	silence, ok := b.Silences["foo.user"]
+	require.True(t, ok)
+
+	peerB, err := config.ReadBombSquadConfig(peerBSConfigurator)
+	require.NoError(t, err)
+	require.Contains(t, peerB.Silences, "foo.user")
+	require.Equal(t, config.OriginPropagated, peerB.Silences["foo.user"].Origin)
+	require.Equal(t, silence.ID, peerB.Silences["foo.user"].ID)
+
+	promConfig, err := config.ReadPromConfig(peerPromConfigurator)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, len(silence.Rules))
+	require.True(t, config.SameRelabelConfig(silence.Rules[0], *promConfig.ScrapeConfigs[0].MetricRelabelConfigs[0]))
+
+	// Nothing new to propagate
+	p.patrol(context.Background())
+	require.Equal(t, 1, peerPromConfigurator.Writes)
+	require.Equal(t, 1, peerBSConfigurator.Writes)
+}
+
+// onQuery calls f before each request to Prometheus
+type onQuery struct {
+	http.RoundTripper
+	f func()
+}
+
+func (o onQuery) RoundTrip(r *http.Request) (*http.Response, error) {
+	o.f()
+	return o.RoundTripper.RoundTrip(r)
+}
+
+// countingLock counts how many times it is held
+type countingLock struct {
+	sync.Mutex
+	held int
+}
+
+func (l *countingLock) Lock() {
+	l.Mutex.Lock()
+	l.held++
+}
+
+func (l *countingLock) Unlock() {
+	l.held--
+	l.Mutex.Unlock()
+}
+
+func TestWriteLockIsFreeWhilePatrolQueries(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	lock := &countingLock{}
+	heldDuringQuery := false
+	p.WriteLock = lock
+	p.HTTPClient.Transport = onQuery{p.HTTPClient.Transport, func() {
+		if lock.held > 0 {
+			heldDuringQuery = true
+		}
+	}}
+
+	p.patrol(context.Background())
+	require.False(t, heldDuringQuery)
+	require.Equal(t, 0, lock.held)
+	require.Equal(t, 1, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+}
+
+func TestPropagatingLeavesPeerPatrolAlone(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+	peer, peerDone := newEscalationPatrol(t, &cardCount)
+	defer peerDone()
+
+	p.Name, p.Peers, p.WriteLock = "shard-0", []*Patrol{peer}, &sync.Mutex{}
+	peer.Name, peer.WriteLock, peer.Interval = "shard-1", &sync.Mutex{}, time.Millisecond
+	p.patrol(context.Background())
+
+	// The peer patrols on its own goroutine while silences are copied to it
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		peer.Run(ctx)
+		close(stopped)
+	}()
+	for i := 0; i < 20; i++ {
+		p.propagateSilences(nil)
+	}
+	cancel()
+	<-stopped
+
+	// Whatever the peer patrolled or was sent went to its own configs
+	peerB, err := config.ReadBombSquadConfig(peer.BSConfigurator)
+	require.NoError(t, err)
+	require.Contains(t, peerB.Silences, "foo.user")
+	promConfig, err := config.ReadPromConfig(peer.PromConfigurator)
+	require.NoError(t, err)
+	require.NotEmpty(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs)
+}
+
+func TestPatrolKeepsConfigsWrittenMeanwhile(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	// A peer propagates a silence while the patrol is querying Prometheus,
+	// after the patrol has read the configs
+	bsConfigurator := p.BSConfigurator.(*bstesting.MemConfigurator)
+	queries := 0
+	p.WriteLock = &sync.Mutex{}
+	p.HTTPClient.Transport = onQuery{p.HTTPClient.Transport, func() {
+		queries++
+		if queries == 3 {
+			require.NoError(t, bsConfigurator.Write([]byte("Version: 2\n")))
+		}
+	}}
+
+	p.patrol(context.Background())
+	require.Equal(t, 0, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, "Version: 2\n", string(bsConfigurator.Data))
+
+	// Redone by the next patrol
+	p.patrol(context.Background())
+	require.Equal(t, 1, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	b, err := config.ReadBombSquadConfig(bsConfigurator)
+	require.NoError(t, err)
+	require.Contains(t, b.Silences, "foo.user")
+}
@@ -0,0 +1,172 @@
+package patrol
+
+import (
+	"log"
+	"sort"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/client_golang/prometheus"
+	promcfg "github.com/prometheus/prometheus/config"
+)
+
+var (
+	DriftMissingRulesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "drift_missing_rules",
+			Help:      "Relabel configs of recorded silences found missing from a scrape config at the last reconciliation",
+		},
+		[]string{"job"},
+	)
+	DriftUnexpectedRulesGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "drift_unexpected_rules",
+			Help:      "Relabel configs that look generated by Bomb Squad but that no silence records, found in a scrape config at the last reconciliation",
+		},
+		[]string{"job"},
+	)
+	DriftReappliedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "drift_reapplied_silences_total",
+			Help:      "Count silences put back in a scrape config after their relabel configs went missing from it",
+		},
+		[]string{"job"},
+	)
+)
+
+// reconcileIfDue reconciles once ReconcileInterval has passed since the last
+// reconciliation. It runs between patrols rather than alongside them so the
+// two never write the configs at the same time.
+func (p *Patrol) reconcileIfDue() {
+	if p.ReconcileInterval <= 0 || time.Since(p.lastReconcile) < p.ReconcileInterval {
+		return
+	}
+	p.reconcileNow()
+}
+
+// RequestReconcile has the patrol reconcile as soon as it's done with what
+// it's doing, ex. because the Prometheus config was changed behind its back.
+// It does nothing if reconciliation is disabled.
+func (p *Patrol) RequestReconcile() {
+	select {
+	case p.reconcileRequests() <- struct{}{}:
+	default:
+		// One is already pending
+	}
+}
+
+func (p *Patrol) reconcileRequests() chan struct{} {
+	p.reconcileOnce.Do(func() {
+		p.reconcileCh = make(chan struct{}, 1)
+	})
+	return p.reconcileCh
+}
+
+func (p *Patrol) reconcileNow() {
+	if p.ReconcileInterval <= 0 {
+		return
+	}
+	p.lastReconcile = time.Now()
+
+	p.lock()
+	defer p.unlock()
+	err := p.reconcile()
+	if err != nil {
+		log.Printf("Couldn't reconcile silences with the Prometheus config: %s\n", err)
+	}
+}
+
+// reconcile compares the silences in the Bomb Squad config with the relabel
+// configs actually in each scrape config, ex. after a `helm upgrade` or a hand
+// edit overwrote the Prometheus config. Silences missing from a scrape config
+// they're scoped to are put back. Relabel configs that look like Bomb Squad's
+// but that no silence records are only reported, since `bs gc` is there to
+// remove them once someone has had a look.
+func (p *Patrol) reconcile() error {
+	garbage, err := config.FindGarbage(p.PromConfigurator, p.BSConfigurator)
+	if err != nil {
+		return err
+	}
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	if err != nil {
+		return err
+	}
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	if err != nil {
+		return err
+	}
+
+	DriftMissingRulesGauge.Reset()
+	DriftUnexpectedRulesGauge.Reset()
+
+	reapplied := 0
+	for _, scrapeConfig := range promConfig.ScrapeConfigs {
+		job := scrapeConfig.JobName
+		missing := 0
+		for _, key := range sortedSilenceKeys(b) {
+			silence := b.Silences[key]
+			if !silence.Scope.HasJob(job) {
+				continue
+			}
+			n := missingRules(silence, *scrapeConfig)
+			if n == 0 {
+				continue
+			}
+			log.Printf("%d relabel configs of silence %s are missing from ScrapeConfig %s, putting them back\n", n, key, job)
+			reapplySilence(silence, scrapeConfig)
+			DriftReappliedCounter.WithLabelValues(job).Inc()
+			missing += n
+			reapplied++
+		}
+		DriftMissingRulesGauge.WithLabelValues(job).Set(float64(missing))
+
+		unexpected := len(garbage.OrphanedRules[job])
+		if unexpected > 0 {
+			log.Printf("Found %d relabel configs in ScrapeConfig %s that look like Bomb Squad's, but that no silence records. Run `bs gc` to remove them.\n", unexpected, job)
+		}
+		DriftUnexpectedRulesGauge.WithLabelValues(job).Set(float64(unexpected))
+	}
+
+	if reapplied == 0 {
+		return nil
+	}
+	return config.WritePromConfig(promConfig, p.PromConfigurator)
+}
+
+func missingRules(silence config.Silence, scrapeConfig promcfg.ScrapeConfig) int {
+	n := 0
+	for _, rule := range silence.Rules {
+		if config.FindRelabelConfigInScrapeConfig(rule, scrapeConfig) < 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// reapplySilence takes out whatever is left of the silence in the scrape
+// config and appends all of its relabel configs again, since silences made of
+// several relabel configs only work with them in order
+func reapplySilence(silence config.Silence, scrapeConfig *promcfg.ScrapeConfig) {
+	for _, rule := range silence.Rules {
+		i := config.FindRelabelConfigInScrapeConfig(rule, *scrapeConfig)
+		if i >= 0 {
+			scrapeConfig.MetricRelabelConfigs = config.DeleteRelabelConfigFromArray(scrapeConfig.MetricRelabelConfigs, i)
+		}
+	}
+	for i := range silence.Rules {
+		rule := silence.Rules[i]
+		scrapeConfig.MetricRelabelConfigs = append(scrapeConfig.MetricRelabelConfigs, &rule)
+	}
+}
+
+func sortedSilenceKeys(b config.BombSquadConfig) []string {
+	keys := []string{}
+	for key := range b.Silences {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,68 @@
+package patrol
+
+import (
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	dto "github.com/prometheus/client_model/go"
+	promcfg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeValue(t *testing.T, g interface {
+	Write(*dto.Metric) error
+}) float64 {
+	m := &dto.Metric{}
+	require.NoError(t, g.Write(m))
+	return m.GetGauge().GetValue()
+}
+
+func TestReconcilePutsBackMissingSilences(t *testing.T) {
+	p := &Patrol{
+		PromConfigurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		BSConfigurator:   bstesting.NewMemConfigurator(t, []byte{}),
+	}
+
+	suppressor := config.TopKSuppressor{K: 1}
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar", Jobs: []string{"prometheus"}, ValueCounts: map[string]int{"a": 10, "b": 1}}
+	rules, err := suppressor.RelabelConfigs(hcs)
+	require.NoError(t, err)
+	for i := range rules {
+		require.NoError(t, prom.ReUnmarshal(&rules[i]))
+	}
+	_, jobs, err := config.InsertMetricRelabelConfigToPromConfig(rules, hcs.Jobs, p.PromConfigurator)
+	require.NoError(t, err)
+	hcs.Jobs = jobs
+	require.NoError(t, config.StoreMetricRelabelConfigBombSquad(hcs, suppressor, rules, p.BSConfigurator))
+
+	// Someone overwrote the Prometheus config, leaving only part of the
+	// silence behind, and a rule of a silence Bomb Squad has no record of
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	stray, err := config.GenerateMetricRelabelConfig(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "baz"})
+	require.NoError(t, err)
+	require.NoError(t, prom.ReUnmarshal(&stray))
+	promConfig.ScrapeConfigs[0].MetricRelabelConfigs = []*promcfg.RelabelConfig{&rules[0], &stray}
+	require.NoError(t, config.WritePromConfig(promConfig, p.PromConfigurator))
+
+	require.NoError(t, p.reconcile())
+	require.Equal(t, float64(len(rules)-1), gaugeValue(t, DriftMissingRulesGauge.WithLabelValues("prometheus")))
+	require.Equal(t, float64(1), gaugeValue(t, DriftUnexpectedRulesGauge.WithLabelValues("prometheus")))
+	require.Equal(t, float64(0), gaugeValue(t, DriftMissingRulesGauge.WithLabelValues("bomb-squad")))
+
+	promConfig, err = config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	mrcs := promConfig.ScrapeConfigs[0].MetricRelabelConfigs
+	require.Len(t, mrcs, len(rules)+1)
+	require.True(t, config.SameRelabelConfig(stray, *mrcs[0]))
+	for i, rule := range rules {
+		require.True(t, config.SameRelabelConfig(rule, *mrcs[i+1]))
+	}
+	require.Empty(t, promConfig.ScrapeConfigs[1].MetricRelabelConfigs)
+
+	// Nothing is missing any more
+	require.NoError(t, p.reconcile())
+	require.Equal(t, float64(0), gaugeValue(t, DriftMissingRulesGauge.WithLabelValues("prometheus")))
+}
@@ -0,0 +1,69 @@
+package patrol
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/common/model"
+)
+
+// RefreshSilence recomputes the values a topk silence keeps from the series
+// Prometheus currently holds for the metric, and replaces the silence's
+// relabel configs to match. Prometheus only holds series for the kept values,
+// everything else having been rewritten to Other, so a refresh can only shrink
+// the kept set, dropping values that have gone away. Values the silence
+// didn't keep are ignored even if series from before it are still around,
+// since their counts can't be compared. pc and bc are the configs to change,
+// ex. the ones Journaled passes.
+func (p *Patrol) RefreshSilence(key string, pc, bc config.Configurator) error {
+	ml := strings.SplitN(key, ".", 2)
+	if len(ml) != 2 {
+		return fmt.Errorf("Expected silence in the form metricName.labelName, got '%s'", key)
+	}
+	metricName, labelName := ml[0], ml[1]
+
+	b, err := config.ReadBombSquadConfig(bc)
+	if err != nil {
+		return err
+	}
+
+	silence, ok := b.Silences[key]
+	if !ok || silence.Kind != config.SilenceLabelValues {
+		return fmt.Errorf("No silence found for %s", key)
+	}
+	if silence.Strategy != config.StrategyTopK {
+		return fmt.Errorf("Only %s silences can be refreshed, %s uses %s", config.StrategyTopK, key, silence.Strategy)
+	}
+
+	suppressor, err := config.NewSuppressor(config.StrategyTopK, b)
+	if err != nil {
+		return err
+	}
+
+	s, err := p.fetchSeries(metricName)
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch series for metric %s: %s", metricName, err)
+	}
+
+	kept := map[string]bool{}
+	for _, v := range silence.KeptValues {
+		kept[v] = true
+	}
+	counts := map[string]int{}
+	for v, n := range valueCounts(s.Data, labelName) {
+		if kept[v] {
+			counts[v] = n
+		}
+	}
+
+	hcs := config.HighCardSeries{
+		MetricName:        metricName,
+		HighCardLabelName: model.LabelName(labelName),
+		Jobs:              silence.Scope.Jobs,
+		Scope:             silence.Scope.Labels,
+		ValueCounts:       counts,
+	}
+
+	return p.silenceSeries(hcs, suppressor, pc, bc)
+}
@@ -0,0 +1,77 @@
+package patrol
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefreshSilence(t *testing.T) {
+	routes := map[string]int{"/users": 5, "/orders": 3, "/x/1": 1}
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		series := []string{}
+		for route, n := range routes {
+			for i := 0; i < n; i++ {
+				series = append(series, fmt.Sprintf(`{"__name__":"foo","job":"prometheus","route":"%s","instance":"%d"}`, route, i))
+			}
+		}
+		w.Write([]byte(`{"status":"success","data":[` + strings.Join(series, ",") + `]}`))
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	p := &Patrol{
+		PromURL:          promurl,
+		HTTPClient:       client,
+		PromConfigurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		BSConfigurator:   bstesting.NewMemConfigurator(t, []byte("TopKValues: 2\n")),
+	}
+
+	require.Error(t, p.RefreshSilence("foo.route", p.PromConfigurator, p.BSConfigurator))
+
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	suppressor, err := config.NewSuppressor(config.StrategyTopK, b)
+	require.NoError(t, err)
+	hcs := config.HighCardSeries{MetricName: "foo", HighCardLabelName: "route", Jobs: []string{"prometheus"}, ValueCounts: routes}
+	require.NoError(t, p.silenceSeries(hcs, suppressor, p.PromConfigurator, p.BSConfigurator))
+
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/orders", "/users"}, b.Silences["foo.route"].KeptValues)
+
+	// Since the silence, /users went away and everything else was rewritten
+	// to other, bar a series of /x/1 from before the silence
+	routes = map[string]int{"/orders": 3, config.DefaultOtherValue: 6, "/x/1": 1}
+	require.NoError(t, p.RefreshSilence("foo.route", p.PromConfigurator, p.BSConfigurator))
+
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/orders"}, b.Silences["foo.route"].KeptValues)
+	require.Equal(t, []string{"prometheus"}, b.Silences["foo.route"].Scope.Jobs)
+
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	require.Len(t, promConfig.ScrapeConfigs[0].MetricRelabelConfigs, 3)
+
+	// Refreshing again only ever keeps fewer values, however many series the
+	// others have
+	routes = map[string]int{"/orders": 1, config.DefaultOtherValue: 20, "/x/1": 10}
+	require.NoError(t, p.RefreshSilence("foo.route", p.PromConfigurator, p.BSConfigurator))
+	b, err = config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	require.Equal(t, []string{"/orders"}, b.Silences["foo.route"].KeptValues)
+}
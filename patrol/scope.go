@@ -0,0 +1,77 @@
+package patrol
+
+import (
+	"sort"
+
+	"github.com/deckarep/golang-set"
+)
+
+const (
+	// minScopeShare is the share of an exploding label's distinct values that
+	// a namespace, pod, etc. must account for to be considered responsible
+	minScopeShare = 0.1
+	// maxScopeValues is how many responsible values a scope label may have
+	// before listing them all in a silence stops being worthwhile
+	maxScopeValues = 20
+)
+
+// scopeExplodingLabel works out which values of each scope label (namespace,
+// pod, ...) are responsible for an exploding label, so that only their series
+// get silenced. Scope labels that don't narrow things down are left out, and
+// nil is returned if none of them do.
+func scopeExplodingLabel(series []map[string]string, explodingLabel string, scopeLabels []string) map[string][]string {
+	total := mapset.NewSet()
+	for _, s := range series {
+		if v, ok := s[explodingLabel]; ok {
+			total.Add(v)
+		}
+	}
+
+	scope := map[string][]string{}
+	for _, scopeLabel := range scopeLabels {
+		if scopeLabel == explodingLabel {
+			continue
+		}
+
+		tracker := labelTracker{}
+		missing := false
+		for _, s := range series {
+			scopeValue, ok := s[scopeLabel]
+			if !ok {
+				// Series without the label would slip past the silence
+				missing = true
+				break
+			}
+			v, ok := s[explodingLabel]
+			if !ok {
+				continue
+			}
+			if _, ok := tracker[scopeValue]; !ok {
+				tracker[scopeValue] = mapset.NewSet()
+			}
+			tracker[scopeValue].Add(v)
+		}
+		if missing {
+			continue
+		}
+
+		responsible := []string{}
+		for scopeValue, values := range tracker {
+			if float64(values.Cardinality()) >= minScopeShare*float64(total.Cardinality()) {
+				responsible = append(responsible, scopeValue)
+			}
+		}
+
+		if len(responsible) == 0 || len(responsible) == len(tracker) || len(responsible) > maxScopeValues {
+			continue
+		}
+
+		sort.Strings(responsible)
+		scope[scopeLabel] = responsible
+	}
+
+	if len(scope) == 0 {
+		return nil
+	}
+	return scope
+}
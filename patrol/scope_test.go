@@ -0,0 +1,25 @@
+package patrol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopeExplodingLabel(t *testing.T) {
+	series := []map[string]string{}
+	for i := 0; i < 100; i++ {
+		series = append(series, map[string]string{"namespace": "bad", "pod": "bad-1", "request_id": fmt.Sprint(i)})
+	}
+	for _, pod := range []string{"good-1", "good-2"} {
+		for i := 0; i < 3; i++ {
+			series = append(series, map[string]string{"namespace": "good", "pod": pod, "request_id": fmt.Sprintf("%s-%d", pod, i)})
+		}
+	}
+
+	scope := scopeExplodingLabel(series, "request_id", []string{"namespace", "pod", "instance"})
+	require.Equal(t, map[string][]string{"namespace": {"bad"}, "pod": {"bad-1"}}, scope)
+
+	require.Nil(t, scopeExplodingLabel(series[:100], "request_id", []string{"namespace", "pod"}))
+}
@@ -0,0 +1,77 @@
+package patrol
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCancelledPatrolWritesNothing(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p.patrol(ctx)
+	require.Equal(t, 0, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+	require.Equal(t, 0, p.BSConfigurator.(*bstesting.MemConfigurator).Writes)
+}
+
+func TestRunStopsWhenCancelled(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	defer done()
+	p.Interval = time.Millisecond
+
+	patrolling := make(chan struct{}, 1)
+	p.IsLeader = func() bool {
+		select {
+		case patrolling <- struct{}{}:
+		default:
+		}
+		return true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(stopped)
+	}()
+
+	<-patrolling
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after being cancelled")
+	}
+
+	// Whatever the patrol under way had changed was either written to both
+	// configs or thrown away
+	promWrites := p.PromConfigurator.(*bstesting.MemConfigurator).Writes
+	require.Equal(t, promWrites, p.BSConfigurator.(*bstesting.MemConfigurator).Writes)
+
+	time.Sleep(10 * time.Millisecond)
+	require.Equal(t, promWrites, p.PromConfigurator.(*bstesting.MemConfigurator).Writes)
+}
+
+func TestCancelledPatrolStopsRetrying(t *testing.T) {
+	cardCount := 1000.
+	p, done := newEscalationPatrol(t, &cardCount)
+	// Prometheus is gone, and would be retried for a long while
+	done()
+	p.Retry = prom.Backoff{Attempts: 10, Initial: time.Minute, Max: time.Minute}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	p.patrol(ctx)
+	require.True(t, time.Since(start) < 5*time.Second)
+	require.Equal(t, 0, p.failedPatrols)
+}
@@ -0,0 +1,43 @@
+package patrol
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/common/model"
+)
+
+// SilenceLabel silences a label of a metric by hand, with the metric's
+// suppression strategy, in the jobs currently emitting the metric. pc and bc
+// are the configs to change, ex. the ones Journaled passes.
+func (p *Patrol) SilenceLabel(key string, pc, bc config.Configurator) error {
+	ml := strings.SplitN(key, ".", 2)
+	if len(ml) != 2 {
+		return fmt.Errorf("Expected silence in the form metricName.labelName, got '%s'", key)
+	}
+	metricName, labelName := ml[0], ml[1]
+
+	suppressor, err := p.suppressorFor(metricName, bc)
+	if err != nil {
+		return err
+	}
+
+	s, err := p.fetchSeries(metricName)
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch series for metric %s: %s", metricName, err)
+	}
+	if len(s.Data) == 0 {
+		return fmt.Errorf("No series found for metric %s", metricName)
+	}
+
+	hcs := config.HighCardSeries{
+		MetricName:        metricName,
+		HighCardLabelName: model.LabelName(labelName),
+		Jobs:              jobsInSeries(s.Data),
+		ValueCounts:       valueCounts(s.Data, labelName),
+		Origin:            config.OriginManual,
+	}
+
+	return p.silenceSeries(hcs, suppressor, pc, bc)
+}
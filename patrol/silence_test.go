@@ -0,0 +1,53 @@
+package patrol
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSilenceLabelByHand(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":[
+			{"__name__":"foo","job":"prometheus","user":"alice"},
+			{"__name__":"foo","job":"prometheus","user":"bob"},
+			{"__name__":"foo","job":"prometheus","user":"bob","instance":"2"}
+		]}`))
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+
+	p := &Patrol{
+		PromURL:          promurl,
+		HTTPClient:       client,
+		PromConfigurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		BSConfigurator:   bstesting.NewMemConfigurator(t, []byte{}),
+	}
+	require.Error(t, p.SilenceLabel("foo", p.PromConfigurator, p.BSConfigurator))
+	require.NoError(t, p.SilenceLabel("foo.user", p.PromConfigurator, p.BSConfigurator))
+
+	b, err := config.ReadBombSquadConfig(p.BSConfigurator)
+	require.NoError(t, err)
+	silence := b.Silences["foo.user"]
+	require.Equal(t, config.OriginManual, silence.Origin)
+	require.Equal(t, config.StrategyReplace, silence.Strategy)
+	require.Equal(t, 2, silence.Cardinality)
+	require.Equal(t, []string{"bob", "alice"}, silence.SampleValues)
+	require.Equal(t, []string{"prometheus"}, silence.Scope.Jobs)
+	require.NotEmpty(t, silence.ID)
+	require.False(t, silence.Created.IsZero())
+
+	promConfig, err := config.ReadPromConfig(p.PromConfigurator)
+	require.NoError(t, err)
+	require.True(t, silence.UsesRule(*promConfig.ScrapeConfigs[0].MetricRelabelConfigs[0]))
+}
@@ -1,8 +1,10 @@
 package prom
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 )
 
@@ -27,20 +29,103 @@ type Series struct {
 	Data   []map[string]string `json:"data"`
 }
 
-// Fetch queries prometheus over http at a given endpoint and returns the body
-func Fetch(endpt string, client *http.Client) ([]byte, error) {
-	req, _ := http.NewRequest("GET", endpt, nil)
+// LabelValues represents the values of a label, as listed by
+// /api/v1/label/<name>/values
+type LabelValues struct {
+	Status string   `json:"status"`
+	Data   []string `json:"data"`
+}
+
+// UnavailableError is returned when Prometheus can't be reached, or answers
+// with a server error, ex. while it restarts. It's worth trying again.
+type UnavailableError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("Prometheus unavailable at %s: %s", e.Endpoint, e.Err)
+}
+
+// BadResponseError is returned when Prometheus turns the request down, or
+// answers with something that can't be unmarshalled. Asking again won't help.
+type BadResponseError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *BadResponseError) Error() string {
+	return fmt.Sprintf("Bad response from Prometheus at %s: %s", e.Endpoint, e.Err)
+}
+
+// IsUnavailable reports whether err means Prometheus couldn't be reached
+func IsUnavailable(err error) bool {
+	_, ok := err.(*UnavailableError)
+	return ok
+}
+
+// apiError is the part of a Prometheus API response that reports failure
+type apiError struct {
+	Status    string `json:"status"`
+	ErrorType string `json:"errorType"`
+	Error     string `json:"error"`
+}
 
-	resp, err := client.Do(req)
+// Fetch queries prometheus over http at a given endpoint and returns the body.
+// It returns an UnavailableError if Prometheus can't be reached or answers
+// with a server error, a BadResponseError if it answers with any other status
+// but 2xx or reports an error in the body, and ctx's error if ctx is done
+// before it answers.
+func Fetch(ctx context.Context, endpt string, client *http.Client) ([]byte, error) {
+	req, err := http.NewRequest("GET", endpt, nil)
 	if err != nil {
-		log.Println("Error in response from p8s client", err)
-		return []byte{}, err
+		return []byte{}, fmt.Errorf("Couldn't query Prometheus at %s: %s", endpt, err)
 	}
 
-	body, _ := ioutil.ReadAll(resp.Body)
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil && ctx.Err() != nil {
+		return []byte{}, ctx.Err()
+	}
+	if err != nil {
+		return []byte{}, &UnavailableError{Endpoint: endpt, Err: err}
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
 
 	// defer can't check error states, and GoMetaLinter complains
 	_ = resp.Body.Close()
 
+	if err != nil && ctx.Err() != nil {
+		return []byte{}, ctx.Err()
+	}
+	if err != nil {
+		return []byte{}, &UnavailableError{Endpoint: endpt, Err: err}
+	}
+	if resp.StatusCode >= 500 {
+		return []byte{}, &UnavailableError{Endpoint: endpt, Err: fmt.Errorf("%s", resp.Status)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return []byte{}, &BadResponseError{Endpoint: endpt, Err: fmt.Errorf("%s", resp.Status)}
+	}
+	ae := apiError{}
+	if json.Unmarshal(body, &ae) == nil && ae.Status == "error" {
+		return []byte{}, &BadResponseError{Endpoint: endpt, Err: fmt.Errorf("%s: %s", ae.ErrorType, ae.Error)}
+	}
+
 	return body, nil
 }
+
+// FetchJSON queries prometheus like Fetch, and unmarshals the body into v. It
+// returns a BadResponseError if the body can't be unmarshalled.
+func FetchJSON(ctx context.Context, endpt string, client *http.Client, v interface{}) error {
+	b, err := Fetch(ctx, endpt, client)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return &BadResponseError{Endpoint: endpt, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package prom
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	promcfg "github.com/prometheus/prometheus/config"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var (
+	ReloadsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "prometheus_reloads_total",
+			Help:      "Count Prometheus config reloads triggered by Bomb Squad, by whether they took effect",
+		},
+		[]string{"result"},
+	)
+	RollbacksCounter = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "bomb_squad",
+			Name:      "prometheus_config_rollbacks_total",
+			Help:      "Count how often Bomb Squad put back the previous Prometheus config after a failed reload",
+		},
+	)
+	LastReloadSuccessfulGauge = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "bomb_squad",
+			Name:      "prometheus_last_reload_successful",
+			Help:      "Whether the last Prometheus config reload triggered by Bomb Squad took effect",
+		},
+	)
+)
+
+// StatusConfig represents Prometheus's /api/v1/status/config response
+type StatusConfig struct {
+	Status string `json:"status"`
+	Data   struct {
+		YAML string `json:"yaml"`
+	} `json:"data"`
+}
+
+// Reloader tells Prometheus to reload its config, and checks that it is
+// running the config that was written
+type Reloader struct {
+	PromURL    *url.URL
+	HTTPClient *http.Client
+	// ConfigFile is where Prometheus's config is mounted, if Bomb Squad can
+	// see it too. When set, reloads wait up to SyncTimeout for the written
+	// config to show up there (ex. for the kubelet to sync a ConfigMap volume).
+	// Otherwise Prometheus gets SyncTimeout on top of VerifyTimeout to report
+	// the written config as loaded.
+	ConfigFile  string
+	SyncTimeout time.Duration
+	// VerifyTimeout is how long Prometheus gets to report the written config
+	// as loaded, being told to reload again until it does
+	VerifyTimeout time.Duration
+	// PollInterval is how often the mounted config and Prometheus are checked
+	// while waiting
+	PollInterval time.Duration
+}
+
+// Timeout is the longest Reload waits for the written config to be loaded,
+// bar the time taken by the requests themselves
+func (r *Reloader) Timeout() time.Duration {
+	return r.SyncTimeout + r.VerifyTimeout
+}
+
+// Reload waits for the written Prometheus config to be visible, reloads
+// Prometheus, and checks that the reload took effect. It gives up with ctx's
+// error once ctx is done.
+func (r *Reloader) Reload(ctx context.Context, written []byte) error {
+	err := r.reload(ctx, written)
+	if err != nil {
+		ReloadsCounter.WithLabelValues("failure").Inc()
+		LastReloadSuccessfulGauge.Set(0)
+		log.Printf("Prometheus config reload failed: %s\n", err)
+		return err
+	}
+	ReloadsCounter.WithLabelValues("success").Inc()
+	LastReloadSuccessfulGauge.Set(1)
+	return nil
+}
+
+func (r *Reloader) reload(ctx context.Context, written []byte) error {
+	if r.ConfigFile != "" {
+		err := r.waitForSync(ctx, written)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := r.postReload(ctx)
+	if err != nil {
+		return err
+	}
+
+	return r.verify(ctx, written)
+}
+
+// postReload tells Prometheus to reload its config
+func (r *Reloader) postReload(ctx context.Context) error {
+	relativeURL, err := url.Parse("/-/reload")
+	if err != nil {
+		return fmt.Errorf("failed to parse relative reload path: %s", err)
+	}
+	req, err := http.NewRequest("POST", r.PromURL.ResolveReference(relativeURL).String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to build reload request: %s", err)
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := r.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to reach Prometheus reload endpoint: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Prometheus refused to reload (%s): %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// waitForSync polls ConfigFile until it holds the written config
+func (r *Reloader) waitForSync(ctx context.Context, written []byte) error {
+	deadline := time.Now().Add(r.SyncTimeout)
+	for {
+		b, err := ioutil.ReadFile(r.ConfigFile)
+		if err == nil && bytes.Equal(b, written) {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out after %s waiting for %s to hold the written config", r.SyncTimeout, r.ConfigFile)
+		}
+		err = r.sleep(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// verify polls Prometheus until the config it reports as loaded has the same
+// scrape-time suppressions as the written config. Prometheus may have
+// reloaded before the written config reached its volume, so it's told to
+// reload again after every check that finds the old config still loaded.
+func (r *Reloader) verify(ctx context.Context, written []byte) error {
+	want := suppressions{}
+	err := yaml.Unmarshal(written, &want)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal written config: %s", err)
+	}
+
+	timeout := r.VerifyTimeout
+	if r.ConfigFile == "" {
+		timeout += r.SyncTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err = r.check(ctx, want)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		err = r.sleep(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = r.postReload(ctx)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// check compares the config Prometheus reports as loaded with want, after
+// making sure Prometheus doesn't report its last reload as failed
+func (r *Reloader) check(ctx context.Context, want suppressions) error {
+	err := r.lastReloadSuccessful(ctx)
+	if err != nil {
+		return err
+	}
+
+	relativeURL, err := url.Parse("/api/v1/status/config")
+	if err != nil {
+		return fmt.Errorf("failed to parse relative api v1 status config path: %s", err)
+	}
+	b, err := Fetch(ctx, r.PromURL.ResolveReference(relativeURL).String(), r.HTTPClient)
+	if err != nil && err == ctx.Err() {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch loaded config from prometheus: %s", err)
+	}
+
+	sc := &StatusConfig{}
+	err = json.Unmarshal(b, sc)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal loaded config from prometheus: %s", err)
+	}
+
+	loaded := suppressions{}
+	err = yaml.Unmarshal([]byte(sc.Data.YAML), &loaded)
+	if err != nil {
+		return fmt.Errorf("Couldn't unmarshal loaded config: %s", err)
+	}
+
+	return compareSuppressions(want, loaded)
+}
+
+// lastReloadSuccessful reads prometheus_config_last_reload_successful from
+// the reloaded Prometheus's own /metrics, rather than from a query that would
+// take in every Prometheus it scrapes. If it isn't there, the loaded config
+// has to speak for itself.
+func (r *Reloader) lastReloadSuccessful(ctx context.Context) error {
+	relativeURL, err := url.Parse("/metrics")
+	if err != nil {
+		return fmt.Errorf("failed to parse relative metrics path: %s", err)
+	}
+	b, err := Fetch(ctx, r.PromURL.ResolveReference(relativeURL).String(), r.HTTPClient)
+	if err != nil && err == ctx.Err() {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch metrics from prometheus: %s", err)
+	}
+
+	parser := expfmt.TextParser{}
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("failed to parse metrics from prometheus: %s", err)
+	}
+	family, ok := families["prometheus_config_last_reload_successful"]
+	if !ok {
+		return nil
+	}
+	for _, m := range family.GetMetric() {
+		if m.GetGauge().GetValue() == 0 {
+			return fmt.Errorf("Prometheus reports its last config reload failed")
+		}
+	}
+	return nil
+}
+
+func (r *Reloader) pollInterval() time.Duration {
+	if r.PollInterval <= 0 {
+		return time.Second
+	}
+	return r.PollInterval
+}
+
+// sleep waits for the next poll, or returns ctx's error if ctx is done first
+func (r *Reloader) sleep(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(r.pollInterval()):
+		return nil
+	}
+}
+
+// suppressions holds just the parts of a Prometheus config that Bomb Squad
+// changes, so that configs rendered by other Prometheus versions still parse
+type suppressions struct {
+	ScrapeConfigs []struct {
+		JobName              string                   `yaml:"job_name"`
+		SampleLimit          uint                     `yaml:"sample_limit,omitempty"`
+		MetricRelabelConfigs []*promcfg.RelabelConfig `yaml:"metric_relabel_configs,omitempty"`
+	} `yaml:"scrape_configs"`
+}
+
+// compareSuppressions checks that every scrape config has the same metric
+// relabel configs and sample_limit in both configs
+func compareSuppressions(want, loaded suppressions) error {
+	w, l := suppressionsByJob(want), suppressionsByJob(loaded)
+	jobs := []string{}
+	for job := range w {
+		jobs = append(jobs, job)
+	}
+	sort.Strings(jobs)
+
+	for _, job := range jobs {
+		if _, ok := l[job]; !ok {
+			return fmt.Errorf("loaded config has no ScrapeConfig %s", job)
+		}
+		if w[job] != l[job] {
+			return fmt.Errorf("loaded config differs from the written one for ScrapeConfig %s", job)
+		}
+	}
+	if len(l) != len(w) {
+		return fmt.Errorf("loaded config has %d ScrapeConfigs, the written one %d", len(l), len(w))
+	}
+	return nil
+}
+
+func suppressionsByJob(c suppressions) map[string]string {
+	res := map[string]string{}
+	for _, sc := range c.ScrapeConfigs {
+		s := fmt.Sprintf("sample_limit=%d", sc.SampleLimit)
+		for _, rc := range sc.MetricRelabelConfigs {
+			s += ";" + config.Fingerprint(*rc)
+		}
+		res[sc.JobName] = s
+	}
+	return res
+}
+
+// EventSink records events operators should know about, such as a rollback
+// of the Prometheus config
+type EventSink interface {
+	Warning(reason, message string)
+}
+
+// ReloadingConfigurator reloads Prometheus after every write to the wrapped
+// Configurator. If the reload fails, the previous config is written back and
+// reloaded.
+type ReloadingConfigurator struct {
+	config.Configurator
+	Reloader *Reloader
+	// Events, if set, is told about every rollback
+	Events EventSink
+	// Context, if set, cuts reloads short once it's done, ex. on shutdown. The
+	// written config is then left in place, without a rollback.
+	Context context.Context
+}
+
+// WriteTimeout is the longest Write waits for reloads, counting the reload of
+// the previous config when rolling back
+func (c ReloadingConfigurator) WriteTimeout() time.Duration {
+	return 2 * c.Reloader.Timeout()
+}
+
+// Write implements github.com/Fresh-Tracks/bomb-squad/config.Configurator
+func (c ReloadingConfigurator) Write(b []byte) error {
+	previous, err := c.Configurator.Read()
+	if err != nil {
+		return err
+	}
+
+	err = c.Configurator.Write(b)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	reloadErr := c.Reloader.Reload(ctx, b)
+	if reloadErr != nil && reloadErr == ctx.Err() {
+		log.Printf("Stopped waiting for Prometheus to load the written config: %s\n", reloadErr)
+		return nil
+	}
+	if reloadErr == nil || bytes.Equal(previous, b) {
+		return reloadErr
+	}
+
+	RollbacksCounter.Inc()
+	msg := fmt.Sprintf("Prometheus didn't load the config Bomb Squad wrote (%s), rolling back to the previous config", reloadErr)
+	log.Println(msg)
+	if c.Events != nil {
+		c.Events.Warning("PrometheusConfigRolledBack", msg)
+	}
+
+	err = c.Configurator.Write(previous)
+	if err != nil {
+		return fmt.Errorf("Reload failed: %s, and rolling back failed too: %s", reloadErr, err)
+	}
+	err = c.Reloader.Reload(ctx, previous)
+	if err != nil {
+		return fmt.Errorf("Reload failed: %s, and reloading the rolled back config failed too: %s", reloadErr, err)
+	}
+
+	return fmt.Errorf("Reload failed, rolled back to the previous Prometheus config: %s", reloadErr)
+}
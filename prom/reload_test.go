@@ -0,0 +1,287 @@
+package prom_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/bstesting"
+	"github.com/Fresh-Tracks/bomb-squad/config"
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	promcfg "github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/require"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// fakePrometheus serves just enough of the Prometheus API for a Reloader
+type fakePrometheus struct {
+	loaded         []byte
+	pending        []byte
+	reloads        int
+	refuseReload   bool
+	reloadFailures bool
+	// lastReloadFailed is what prometheus_config_last_reload_successful
+	// reports, whatever config is loaded
+	lastReloadFailed bool
+	// syncedAfter is how many reloads happen before the pending config
+	// reaches Prometheus's volume
+	syncedAfter int
+}
+
+func (f *fakePrometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/-/reload":
+		if f.refuseReload {
+			http.Error(w, "failed to reload config", http.StatusInternalServerError)
+			return
+		}
+		f.reloads++
+		if !f.reloadFailures && f.reloads > f.syncedAfter {
+			f.loaded = f.pending
+		}
+	case "/api/v1/status/config":
+		sc := prom.StatusConfig{Status: "success"}
+		sc.Data.YAML = string(f.loaded)
+		json.NewEncoder(w).Encode(sc)
+	case "/metrics":
+		successful := 1
+		if f.lastReloadFailed {
+			successful = 0
+		}
+		fmt.Fprintf(w, "# TYPE prometheus_config_last_reload_successful gauge\nprometheus_config_last_reload_successful %d\n", successful)
+	}
+}
+
+func newReloader(t *testing.T, f *fakePrometheus) (*prom.Reloader, func()) {
+	s := httptest.NewServer(f)
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+
+	return &prom.Reloader{
+		PromURL:      promurl,
+		HTTPClient:   client,
+		PollInterval: time.Millisecond,
+	}, s.Close
+}
+
+func silencedPromConfig(t *testing.T) []byte {
+	c := bstesting.NewMemConfigurator(t, bstesting.PromConfig())
+	rc, err := config.GenerateMetricRelabelConfig(config.HighCardSeries{MetricName: "foo", HighCardLabelName: "bar"})
+	require.NoError(t, err)
+	require.NoError(t, prom.ReUnmarshal(&rc))
+	pc, _, err := config.InsertMetricRelabelConfigToPromConfig([]promcfg.RelabelConfig{rc}, nil, c)
+	require.NoError(t, err)
+	b, err := yaml.Marshal(pc)
+	require.NoError(t, err)
+	return b
+}
+
+func TestReloadVerifiesLoadedConfig(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), pending: written}
+	r, done := newReloader(t, f)
+	defer done()
+
+	require.NoError(t, r.Reload(context.Background(), written))
+	require.Equal(t, 1, f.reloads)
+}
+
+func TestReloadRetriesUntilConfigIsSynced(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), pending: written, syncedAfter: 2}
+	r, done := newReloader(t, f)
+	defer done()
+	r.VerifyTimeout = time.Second
+
+	require.NoError(t, r.Reload(context.Background(), written))
+	require.Equal(t, 3, f.reloads)
+}
+
+func TestReloadWaitsForSyncWithoutConfigFile(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), pending: written, syncedAfter: 2}
+	r, done := newReloader(t, f)
+	defer done()
+	r.SyncTimeout = time.Second
+
+	require.NoError(t, r.Reload(context.Background(), written))
+}
+
+func TestReloadFailures(t *testing.T) {
+	written := silencedPromConfig(t)
+
+	f := &fakePrometheus{pending: written, refuseReload: true}
+	r, done := newReloader(t, f)
+	defer done()
+	require.Error(t, r.Reload(context.Background(), written))
+
+	// Prometheus kept running the old config
+	f = &fakePrometheus{pending: bstesting.PromConfig()}
+	r, done = newReloader(t, f)
+	defer done()
+	require.Error(t, r.Reload(context.Background(), written))
+
+	f = &fakePrometheus{pending: written, reloadFailures: true}
+	r, done = newReloader(t, f)
+	defer done()
+	require.Error(t, r.Reload(context.Background(), written))
+}
+
+func TestReloadFailsWhenPrometheusReportsFailure(t *testing.T) {
+	written := silencedPromConfig(t)
+
+	// The written config was already loaded, but reloading it this time
+	// failed, ex. on a rule file
+	f := &fakePrometheus{loaded: written, pending: written, lastReloadFailed: true}
+	r, done := newReloader(t, f)
+	defer done()
+	err := r.Reload(context.Background(), written)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "last config reload failed")
+
+	f.lastReloadFailed = false
+	require.NoError(t, r.Reload(context.Background(), written))
+}
+
+func TestReloadWaitsForConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bomb-squad")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{pending: written}
+	r, done := newReloader(t, f)
+	defer done()
+
+	r.ConfigFile = filepath.Join(dir, "prometheus.yml")
+	require.NoError(t, ioutil.WriteFile(r.ConfigFile, bstesting.PromConfig(), 0644))
+	require.Error(t, r.Reload(context.Background(), written))
+	require.Equal(t, 0, f.reloads)
+
+	r.SyncTimeout = time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		ioutil.WriteFile(r.ConfigFile, written, 0644)
+	}()
+	require.NoError(t, r.Reload(context.Background(), written))
+	require.Equal(t, 1, f.reloads)
+}
+
+func TestReloadingConfigurator(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{}
+	r, done := newReloader(t, f)
+	defer done()
+
+	c := prom.ReloadingConfigurator{
+		Configurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		Reloader:     r,
+	}
+	f.pending = written
+	require.NoError(t, c.Write(written))
+	require.Equal(t, 1, f.reloads)
+
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, written, b)
+}
+
+func TestReloadingConfiguratorRollsBack(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), reloadFailures: true}
+	r, done := newReloader(t, f)
+	defer done()
+
+	events := &fakeEventSink{}
+	c := prom.ReloadingConfigurator{
+		Configurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		Reloader:     r,
+		Events:       events,
+	}
+
+	require.Error(t, c.Write(written))
+	require.Equal(t, []string{"PrometheusConfigRolledBack"}, events.reasons)
+
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, bstesting.PromConfig(), b)
+}
+
+func TestReloadStopsWhenCancelled(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), reloadFailures: true}
+	r, done := newReloader(t, f)
+	defer done()
+	r.VerifyTimeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	require.Equal(t, context.DeadlineExceeded, r.Reload(ctx, written))
+	require.True(t, time.Since(start) < 5*time.Second)
+
+	// Likewise while waiting for the config to be synced
+	dir, err := ioutil.TempDir("", "bomb-squad")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	r.ConfigFile = filepath.Join(dir, "prometheus.yml")
+	r.SyncTimeout = time.Minute
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	require.Equal(t, context.DeadlineExceeded, r.Reload(ctx, written))
+	require.True(t, time.Since(start) < 5*time.Second)
+}
+
+func TestReloadingConfiguratorKeepsConfigWhenCancelled(t *testing.T) {
+	written := silencedPromConfig(t)
+	f := &fakePrometheus{loaded: bstesting.PromConfig(), reloadFailures: true}
+	r, done := newReloader(t, f)
+	defer done()
+	r.VerifyTimeout = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	events := &fakeEventSink{}
+	c := prom.ReloadingConfigurator{
+		Configurator: bstesting.NewMemConfigurator(t, bstesting.PromConfig()),
+		Reloader:     r,
+		Events:       events,
+		Context:      ctx,
+	}
+
+	// Shutting down mid-reload leaves the written config for Prometheus to
+	// load, rather than roll back without being able to reload
+	require.NoError(t, c.Write(written))
+	require.Empty(t, events.reasons)
+	b, err := c.Read()
+	require.NoError(t, err)
+	require.Equal(t, written, b)
+}
+
+type fakeEventSink struct {
+	reasons []string
+}
+
+func (f *fakeEventSink) Warning(reason, message string) {
+	f.reasons = append(f.reasons, reason)
+}
+
+func TestReloadingConfiguratorWriteTimeout(t *testing.T) {
+	c := prom.ReloadingConfigurator{
+		Reloader: &prom.Reloader{SyncTimeout: 2 * time.Minute, VerifyTimeout: 30 * time.Second},
+	}
+	// A rollback reloads the previous config too
+	require.Equal(t, 5*time.Minute, c.WriteTimeout())
+}
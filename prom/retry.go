@@ -0,0 +1,61 @@
+package prom
+
+import (
+	"context"
+	"log"
+	"math/rand"
+	"time"
+)
+
+// DefaultBackoff retries for up to about 5 seconds, about as long as
+// Prometheus takes to come back from a restart
+var DefaultBackoff = Backoff{
+	Attempts: 4,
+	Initial:  500 * time.Millisecond,
+	Max:      4 * time.Second,
+}
+
+// Backoff retries calls to Prometheus while it's unavailable, waiting twice
+// as long after each attempt, up to Max. Waits are jittered so that the Bomb
+// Squads of several Prometheus replicas don't retry in lockstep. The zero
+// Backoff tries once.
+type Backoff struct {
+	// Attempts is how many times to try in all
+	Attempts int
+	Initial  time.Duration
+	Max      time.Duration
+}
+
+// Retry calls f until it succeeds, fails with anything but an
+// UnavailableError, or runs out of attempts, and returns its last error. It
+// stops waiting to try again, and returns ctx's error, once ctx is done.
+func (b Backoff) Retry(ctx context.Context, f func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = f()
+		if err == nil || !IsUnavailable(err) || attempt+1 >= b.Attempts {
+			return err
+		}
+
+		d := b.delay(attempt)
+		log.Printf("%s, trying again in %s\n", err, d)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// delay returns how long to wait after the attempt, somewhere between half
+// and all of the exponential delay
+func (b Backoff) delay(attempt int) time.Duration {
+	d := b.Initial << uint(attempt)
+	if d > b.Max || d <= 0 {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
@@ -0,0 +1,125 @@
+package prom_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+var quickBackoff = prom.Backoff{
+	Attempts: 3,
+	Initial:  time.Millisecond,
+	Max:      2 * time.Millisecond,
+}
+
+func TestFetchJSONTypesErrors(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`not json`))
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+
+	iq := &prom.InstantQuery{}
+	err = prom.FetchJSON(context.Background(), s.URL, client, iq)
+	require.IsType(t, &prom.UnavailableError{}, err)
+	require.True(t, prom.IsUnavailable(err))
+
+	status = http.StatusOK
+	err = prom.FetchJSON(context.Background(), s.URL, client, iq)
+	require.IsType(t, &prom.BadResponseError{}, err)
+	require.False(t, prom.IsUnavailable(err))
+
+	s.Close()
+	err = prom.FetchJSON(context.Background(), s.URL, client, iq)
+	require.True(t, prom.IsUnavailable(err))
+}
+
+func TestFetchRejectsErrorResponses(t *testing.T) {
+	status, body := http.StatusBadRequest, `{"status":"error","errorType":"bad_data","error":"parse error"}`
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer s.Close()
+
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+	fetch := func() error {
+		return quickBackoff.Retry(context.Background(), func() error {
+			_, err := prom.Fetch(context.Background(), s.URL, client)
+			return err
+		})
+	}
+
+	// Asking again won't help
+	err = fetch()
+	require.IsType(t, &prom.BadResponseError{}, err)
+	require.Equal(t, 1, requests)
+
+	status, requests = http.StatusNotModified, 0
+	require.IsType(t, &prom.BadResponseError{}, fetch())
+	require.Equal(t, 1, requests)
+
+	status, requests = http.StatusOK, 0
+	err = fetch()
+	require.IsType(t, &prom.BadResponseError{}, err)
+	require.Contains(t, err.Error(), "parse error")
+	require.Equal(t, 1, requests)
+
+	body = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+	require.NoError(t, fetch())
+}
+
+func TestRetryWhileUnavailable(t *testing.T) {
+	calls := 0
+	err := quickBackoff.Retry(context.Background(), func() error {
+		calls++
+		if calls < 3 {
+			return &prom.UnavailableError{Endpoint: "/api/v1/query", Err: fmt.Errorf("connection refused")}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	calls := 0
+	err := quickBackoff.Retry(context.Background(), func() error {
+		calls++
+		return &prom.UnavailableError{Endpoint: "/api/v1/query", Err: fmt.Errorf("connection refused")}
+	})
+	require.True(t, prom.IsUnavailable(err))
+	require.Equal(t, 3, calls)
+}
+
+func TestRetryOnlyUnavailable(t *testing.T) {
+	calls := 0
+	err := quickBackoff.Retry(context.Background(), func() error {
+		calls++
+		return &prom.BadResponseError{Endpoint: "/api/v1/query", Err: fmt.Errorf("unexpected end of JSON input")}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+
+	calls = 0
+	err = prom.Backoff{}.Retry(context.Background(), func() error {
+		calls++
+		return &prom.UnavailableError{Endpoint: "/api/v1/query", Err: fmt.Errorf("connection refused")}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls)
+}
@@ -0,0 +1,61 @@
+package prom
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Targets represents Prometheus's /api/v1/targets response
+type Targets struct {
+	Status string `json:"status"`
+	Data   struct {
+		ActiveTargets []struct {
+			ScrapePool string            `json:"scrapePool"`
+			Labels     map[string]string `json:"labels"`
+		} `json:"activeTargets"`
+	} `json:"data"`
+}
+
+// ScrapePoolJobs returns the job label values of the active targets in each
+// scrape pool. Scrape pools are named after the job_name of their scrape
+// config, which needn't be the job label the series carry.
+func ScrapePoolJobs(ctx context.Context, promurl *url.URL, client *http.Client) (map[string][]string, error) {
+	relativeURL, err := url.Parse("/api/v1/targets")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse relative api v1 targets path: %s", err)
+	}
+
+	b, err := Fetch(ctx, promurl.ResolveReference(relativeURL).String(), client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch targets from prometheus: %s", err)
+	}
+
+	targets := &Targets{}
+	err = json.Unmarshal(b, targets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal targets from prometheus: %s", err)
+	}
+
+	seen := map[string]map[string]bool{}
+	res := map[string][]string{}
+	for _, t := range targets.Data.ActiveTargets {
+		job := t.Labels["job"]
+		if seen[t.ScrapePool] == nil {
+			seen[t.ScrapePool] = map[string]bool{}
+		}
+		if job == "" || seen[t.ScrapePool][job] {
+			continue
+		}
+		seen[t.ScrapePool][job] = true
+		res[t.ScrapePool] = append(res[t.ScrapePool], job)
+	}
+	for _, jobs := range res {
+		sort.Strings(jobs)
+	}
+
+	return res, nil
+}
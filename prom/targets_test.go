@@ -0,0 +1,37 @@
+package prom_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/Fresh-Tracks/bomb-squad/prom"
+	"github.com/Fresh-Tracks/bomb-squad/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapePoolJobs(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"success","data":{"activeTargets":[
+			{"scrapePool":"serviceMonitor/default/app/0","labels":{"job":"app","instance":"10.0.0.1:8080"}},
+			{"scrapePool":"serviceMonitor/default/app/0","labels":{"job":"app","instance":"10.0.0.2:8080"}},
+			{"scrapePool":"podMonitor/default/pods/0","labels":{"job":"pods-b"}},
+			{"scrapePool":"podMonitor/default/pods/0","labels":{"job":"pods-a"}}
+		]}}`))
+	}))
+	defer s.Close()
+
+	promurl, err := url.Parse(s.URL)
+	require.NoError(t, err)
+	client, err := util.HttpClient()
+	require.NoError(t, err)
+
+	jobs, err := prom.ScrapePoolJobs(context.Background(), promurl, client)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"serviceMonitor/default/app/0": {"app"},
+		"podMonitor/default/pods/0":    {"pods-a", "pods-b"},
+	}, jobs)
+}